@@ -30,6 +30,7 @@ type Request struct {
 	Policy     *config.Policy
 	HTTP       RequestHTTP
 	Session    RequestSession
+	Route      *RequestRoute
 }
 
 // RequestHTTP is the HTTP field in the request.
@@ -41,6 +42,19 @@ type RequestHTTP struct {
 	Headers           map[string]string     `json:"headers"`
 	ClientCertificate ClientCertificateInfo `json:"client_certificate"`
 	IP                string                `json:"ip"`
+	// Geo is the result of a geolocation lookup of the client's IP address.
+	// Pomerium has no geolocation database, so authorize's Check never sets
+	// it: it's for a caller of Evaluate with its own lookup. Without it the
+	// geo criterion denies, with the geo-unauthorized reason.
+	Geo *RequestGeo `json:"geo,omitempty"`
+}
+
+// RequestGeo is the geolocation of the client in the request.
+type RequestGeo struct {
+	// Country is an ISO 3166-1 alpha-2 code.
+	Country string `json:"country,omitempty"`
+	// ASN is the number of the autonomous system announcing the address.
+	ASN int `json:"asn,omitempty"`
 }
 
 // NewRequestHTTP creates a new RequestHTTP.
@@ -79,6 +93,34 @@ type ClientCertificateInfo struct {
 // RequestSession is the session field in the request.
 type RequestSession struct {
 	ID string `json:"id"`
+	// IDPID is the id of the identity provider which authenticated the
+	// session.
+	IDPID string `json:"idp_id,omitempty"`
+	// FailedAttempts is the number of prior failed authentication attempts.
+	// Pomerium doesn't count them, so authorize's Check never sets it: it's
+	// for a caller of Evaluate with its own count, e.g. from an external
+	// lockout service. Without it the failed_attempts criterion denies, with
+	// the failed-attempts-unauthorized reason.
+	FailedAttempts *int `json:"failed_attempts,omitempty"`
+	// Rate is the number of requests made by the user in the last minute.
+	// Pomerium doesn't count them, so authorize's Check never sets it: it's
+	// for a caller of Evaluate with its own count, e.g. from a rate limiter in
+	// front of the proxy. Without it the rate criterion denies, with the
+	// rate-unauthorized reason.
+	Rate *int `json:"rate,omitempty"`
+}
+
+// RequestRoute is the route field in the request.
+type RequestRoute struct {
+	// ID is the id of the route, as passed by envoy to ext_authz.
+	ID string `json:"id,omitempty"`
+	// Internal is true for Pomerium's own routes, like the routes under
+	// /.pomerium/. Those are authorized by evaluateInternal rather than the
+	// route's policy.
+	Internal bool `json:"internal,omitempty"`
+	// Flags are the boolean flags of the route, from the route's flags option.
+	// The route_flag criterion doesn't match a flag which isn't set.
+	Flags map[string]bool `json:"flags,omitempty"`
 }
 
 // Result is the result of evaluation.
@@ -287,6 +329,7 @@ func (e *Evaluator) evaluatePolicy(ctx context.Context, req *Request) (*PolicyRe
 		HTTP:                     req.HTTP,
 		Session:                  req.Session,
 		IsValidClientCertificate: isValidClientCertificate,
+		Route:                    req.Route,
 	})
 }
 
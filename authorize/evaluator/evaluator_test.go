@@ -127,6 +127,67 @@ func TestEvaluator(t *testing.T) {
 				},
 			},
 		},
+		{
+			To: config.WeightedURLs{{URL: *mustParseURL("https://to13.example.com")}},
+			Policy: &config.PPLPolicy{
+				Policy: &parser.Policy{
+					Rules: []parser.Rule{{
+						Action: parser.ActionAllow,
+						And: []parser.Criterion{{
+							Name: "failed_attempts", Data: parser.Object{
+								"max": parser.Number("3"),
+							},
+						}},
+					}},
+				},
+			},
+		},
+		{
+			To: config.WeightedURLs{{URL: *mustParseURL("https://to14.example.com")}},
+			Policy: &config.PPLPolicy{
+				Policy: &parser.Policy{
+					Rules: []parser.Rule{{
+						Action: parser.ActionAllow,
+						And: []parser.Criterion{{
+							Name: "rate", Data: parser.Object{
+								"max": parser.Number("100"),
+							},
+						}},
+					}},
+				},
+			},
+		},
+		{
+			To: config.WeightedURLs{{URL: *mustParseURL("https://to15.example.com")}},
+			Policy: &config.PPLPolicy{
+				Policy: &parser.Policy{
+					Rules: []parser.Rule{{
+						Action: parser.ActionAllow,
+						And: []parser.Criterion{{
+							Name: "geo", Data: parser.Object{
+								"country": parser.String("DE"),
+							},
+						}},
+					}},
+				},
+			},
+		},
+		{
+			To: config.WeightedURLs{{URL: *mustParseURL("https://to16.example.com")}},
+			Policy: &config.PPLPolicy{
+				Policy: &parser.Policy{
+					Rules: []parser.Rule{{
+						Action: parser.ActionAllow,
+						And: []parser.Criterion{{
+							Name: "pomerium_routes", Data: parser.Object{
+								"internal": parser.Boolean(false),
+								"route_id": parser.String("1234"),
+							},
+						}},
+					}},
+				},
+			},
+		},
 	}
 	options := []Option{
 		WithAuthenticateURL("https://authn.example.com"),
@@ -552,6 +613,141 @@ func TestEvaluator(t *testing.T) {
 		require.NoError(t, err)
 		assert.True(t, res.Allow.Value)
 	})
+	t.Run("failed attempts", func(t *testing.T) {
+		req := func(failedAttempts *int) *Request {
+			return &Request{
+				Policy: &policies[12],
+				HTTP: NewRequestHTTP(
+					http.MethodGet,
+					*mustParseURL("https://from.example.com/"),
+					nil,
+					ClientCertificateInfo{},
+					"",
+				),
+				Session: RequestSession{ID: "session1", FailedAttempts: failedAttempts},
+			}
+		}
+		attempts := func(n int) *int { return &n }
+
+		t.Run("missing", func(t *testing.T) {
+			// as from authorize's Check, which doesn't count failed attempts
+			res, err := eval(t, options, []proto.Message{}, req(nil))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonFailedAttemptsUnauthorized), res.Allow)
+		})
+		t.Run("under", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(attempts(2)))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(true, criteria.ReasonFailedAttemptsOK), res.Allow)
+		})
+		t.Run("over", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(attempts(4)))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonFailedAttemptsUnauthorized), res.Allow)
+		})
+	})
+	t.Run("rate", func(t *testing.T) {
+		req := func(rate *int) *Request {
+			return &Request{
+				Policy: &policies[13],
+				HTTP: NewRequestHTTP(
+					http.MethodGet,
+					*mustParseURL("https://from.example.com/"),
+					nil,
+					ClientCertificateInfo{},
+					"",
+				),
+				Session: RequestSession{ID: "session1", Rate: rate},
+			}
+		}
+		rate := func(n int) *int { return &n }
+
+		t.Run("missing", func(t *testing.T) {
+			// as from authorize's Check, which doesn't count requests
+			res, err := eval(t, options, []proto.Message{}, req(nil))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonRateUnauthorized), res.Allow)
+		})
+		t.Run("under", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(rate(42)))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(true, criteria.ReasonRateOK), res.Allow)
+		})
+		t.Run("over", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(rate(101)))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonRateUnauthorized), res.Allow)
+		})
+	})
+	t.Run("geo", func(t *testing.T) {
+		req := func(geo *RequestGeo) *Request {
+			httpReq := NewRequestHTTP(
+				http.MethodGet,
+				*mustParseURL("https://from.example.com/"),
+				nil,
+				ClientCertificateInfo{},
+				"192.0.2.1",
+			)
+			httpReq.Geo = geo
+			return &Request{
+				Policy: &policies[14],
+				HTTP:   httpReq,
+			}
+		}
+
+		t.Run("missing", func(t *testing.T) {
+			// as from authorize's Check, which doesn't look up the client's location
+			res, err := eval(t, options, []proto.Message{}, req(nil))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonGeoUnauthorized), res.Allow)
+		})
+		t.Run("allowed country", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(&RequestGeo{Country: "DE"}))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(true, criteria.ReasonGeoOK), res.Allow)
+		})
+		t.Run("other country", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(&RequestGeo{Country: "FR"}))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonGeoUnauthorized), res.Allow)
+		})
+	})
+	t.Run("pomerium routes", func(t *testing.T) {
+		req := func(route *RequestRoute) *Request {
+			return &Request{
+				Policy: &policies[15],
+				HTTP: NewRequestHTTP(
+					http.MethodGet,
+					*mustParseURL("https://from.example.com/"),
+					nil,
+					ClientCertificateInfo{},
+					"",
+				),
+				Route: route,
+			}
+		}
+
+		t.Run("route id", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(&RequestRoute{ID: "1234"}))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(true, criteria.ReasonRouteIDOK), res.Allow)
+		})
+		t.Run("other route id", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(&RequestRoute{ID: "5678"}))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonRouteIDUnauthorized), res.Allow)
+		})
+		t.Run("internal", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(&RequestRoute{ID: "1234", Internal: true}))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonRouteIDUnauthorized), res.Allow)
+		})
+		t.Run("missing", func(t *testing.T) {
+			res, err := eval(t, options, []proto.Message{}, req(nil))
+			require.NoError(t, err)
+			assert.Equal(t, NewRuleResult(false, criteria.ReasonRouteIDUnauthorized), res.Allow)
+		})
+	})
 }
 
 func TestPolicyEvaluatorReuse(t *testing.T) {
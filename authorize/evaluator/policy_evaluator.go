@@ -23,6 +23,7 @@ type PolicyRequest struct {
 	HTTP                     RequestHTTP    `json:"http"`
 	Session                  RequestSession `json:"session"`
 	IsValidClientCertificate bool           `json:"is_valid_client_certificate"`
+	Route                    *RequestRoute  `json:"route,omitempty"`
 }
 
 // PolicyResponse is the result of evaluating a policy.
@@ -160,6 +161,7 @@ func NewPolicyEvaluator(
 			rego.EnablePrintStatements(true),
 			getGoogleCloudServerlessHeadersRegoOption,
 			store.GetDataBrokerRecordOption(),
+			criteria.IDNAToASCIIRegoOption,
 		)
 
 		q, err := r.PrepareForEval(ctx)
@@ -172,6 +174,7 @@ func NewPolicyEvaluator(
 				rego.EnablePrintStatements(true),
 				getGoogleCloudServerlessHeadersRegoOption,
 				store.GetDataBrokerRecordOption(),
+				criteria.IDNAToASCIIRegoOption,
 			)
 			q, err = r.PrepareForEval(ctx)
 		}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
@@ -111,10 +112,19 @@ func (a *Authorize) getEvaluatorRequestFromCheckRequest(
 	}
 	if sessionState != nil {
 		req.Session = evaluator.RequestSession{
-			ID: sessionState.ID,
+			ID:    sessionState.ID,
+			IDPID: sessionState.IdentityProviderID,
 		}
 	}
-	req.Policy = a.getMatchingPolicy(envoyconfig.ExtAuthzContextExtensionsRouteID(attrs.GetContextExtensions()))
+	routeID := envoyconfig.ExtAuthzContextExtensionsRouteID(attrs.GetContextExtensions())
+	req.Policy = a.getMatchingPolicy(routeID)
+	req.Route = &evaluator.RequestRoute{
+		Internal: req.IsInternal,
+	}
+	if req.Policy != nil {
+		req.Route.ID = strconv.FormatUint(routeID, 10)
+		req.Route.Flags = req.Policy.Flags
+	}
 	return req, nil
 }
 
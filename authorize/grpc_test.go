@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"strconv"
 	"testing"
 
 	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/pomerium/pomerium/authorize/evaluator"
 	"github.com/pomerium/pomerium/config"
+	"github.com/pomerium/pomerium/config/envoyconfig"
 	"github.com/pomerium/pomerium/internal/atomicutil"
 	"github.com/pomerium/pomerium/internal/sessions"
 	"github.com/pomerium/pomerium/internal/testutil"
@@ -56,6 +58,7 @@ func Test_getEvaluatorRequest(t *testing.T) {
 			SubPolicies: []config.SubPolicy{{
 				Rego: []string{"allow = true"},
 			}},
+			Flags: map[string]bool{"beta": true},
 		}},
 	})
 
@@ -89,14 +92,20 @@ func Test_getEvaluatorRequest(t *testing.T) {
 			},
 		},
 		&sessions.State{
-			ID: "SESSION_ID",
+			ID:                 "SESSION_ID",
+			IdentityProviderID: "IDP_ID",
 		},
 	)
 	require.NoError(t, err)
 	expect := &evaluator.Request{
 		Policy: &a.currentOptions.Load().Policies[0],
 		Session: evaluator.RequestSession{
-			ID: "SESSION_ID",
+			ID:    "SESSION_ID",
+			IDPID: "IDP_ID",
+		},
+		Route: &evaluator.RequestRoute{
+			ID:    "0",
+			Flags: map[string]bool{"beta": true},
 		},
 		HTTP: evaluator.NewRequestHTTP(
 			http.MethodGet,
@@ -150,6 +159,7 @@ func Test_getEvaluatorRequestWithPortInHostHeader(t *testing.T) {
 	expect := &evaluator.Request{
 		Policy:  &a.currentOptions.Load().Policies[0],
 		Session: evaluator.RequestSession{},
+		Route:   &evaluator.RequestRoute{ID: "0"},
 		HTTP: evaluator.NewRequestHTTP(
 			http.MethodGet,
 			mustParseURL("http://example.com/some/path?qs=1"),
@@ -164,6 +174,52 @@ func Test_getEvaluatorRequestWithPortInHostHeader(t *testing.T) {
 	assert.Equal(t, expect, actual)
 }
 
+func Test_getEvaluatorRequestRoute(t *testing.T) {
+	a := &Authorize{currentOptions: config.NewAtomicOptions(), state: atomicutil.NewValue(new(authorizeState))}
+	a.currentOptions.Store(&config.Options{
+		Policies: []config.Policy{{
+			From:  "https://example.com",
+			To:    config.WeightedURLs{{URL: mustParseURL("https://to.example.com")}},
+			Flags: map[string]bool{"beta": true},
+		}},
+	})
+	routeID, err := a.currentOptions.Load().Policies[0].RouteID()
+	require.NoError(t, err)
+
+	getRoute := func(t *testing.T, contextExtensions map[string]string) *evaluator.RequestRoute {
+		t.Helper()
+
+		req, err := a.getEvaluatorRequestFromCheckRequest(context.Background(),
+			&envoy_service_auth_v3.CheckRequest{
+				Attributes: &envoy_service_auth_v3.AttributeContext{
+					Request: &envoy_service_auth_v3.AttributeContext_Request{
+						Http: &envoy_service_auth_v3.AttributeContext_HttpRequest{
+							Method: http.MethodGet,
+							Path:   "/.pomerium/",
+							Host:   "example.com",
+							Scheme: "https",
+						},
+					},
+					ContextExtensions: contextExtensions,
+				},
+			}, nil)
+		require.NoError(t, err)
+		return req.Route
+	}
+
+	t.Run("external", func(t *testing.T) {
+		assert.Equal(t, &evaluator.RequestRoute{
+			ID:    strconv.FormatUint(routeID, 10),
+			Flags: map[string]bool{"beta": true},
+		}, getRoute(t, envoyconfig.MakeExtAuthzContextExtensions(false, routeID)))
+	})
+	t.Run("internal", func(t *testing.T) {
+		assert.Equal(t, &evaluator.RequestRoute{
+			Internal: true,
+		}, getRoute(t, envoyconfig.MakeExtAuthzContextExtensions(true, 0)))
+	})
+}
+
 func Test_getClientCertificateInfo(t *testing.T) {
 	const leafPEM = `-----BEGIN CERTIFICATE-----
 MIIBZTCCAQugAwIBAgICEAEwCgYIKoZIzj0EAwIwGjEYMBYGA1UEAxMPSW50ZXJt
@@ -176,6 +176,9 @@ type Policy struct {
 	// ShowErrorDetails indicates whether or not additional error details should be displayed.
 	ShowErrorDetails bool `mapstructure:"show_error_details" yaml:"show_error_details" json:"show_error_details"`
 
+	// Flags are boolean flags of the route, matched by the route_flag policy criterion.
+	Flags map[string]bool `mapstructure:"flags" yaml:"flags,omitempty" json:"flags,omitempty"`
+
 	Policy *PPLPolicy `mapstructure:"policy" yaml:"policy,omitempty" json:"policy,omitempty"`
 }
 
@@ -0,0 +1,83 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The time the user last authenticated is when their session was issued. If
+// there's no session the user can't have authenticated recently.
+var authAgeBody = ast.MustParseBody(`
+	session := get_session(input.session.id)
+	auth_time := object.get(session, ["issued_at", "seconds"], null)
+	is_number(auth_time)
+	time.now_ns() - auth_time * 1000000000 <= max_auth_age
+`)
+
+type authAgeCriterion struct {
+	g *Generator
+}
+
+func (authAgeCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (authAgeCriterion) Name() string {
+	return "auth_age"
+}
+
+// GenerateRule generates a rule which matches if the user authenticated
+// within the maximum age, like:
+//
+//	allow:
+//	  and:
+//	    - auth_age:
+//	        max: 5m
+func (c authAgeCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for auth_age criterion, got: %T", data)
+	}
+
+	var body ast.Body
+	for k, v := range obj {
+		switch k {
+		case "max":
+			d, err := parseDuration("auth_age max", v)
+			if err != nil {
+				return nil, nil, err
+			}
+			body = append(body, ast.Assign.Expr(ast.VarTerm("max_auth_age"), durationTerm(d)))
+		default:
+			return nil, nil, fmt.Errorf("unsupported auth_age condition: %s", k)
+		}
+	}
+	if len(body) == 0 {
+		return nil, nil, errors.New("auth_age criterion requires max")
+	}
+	body = append(body, authAgeBody...)
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonAuthAgeOK, ReasonAuthAgeUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetSession(),
+	}, nil
+}
+
+// AuthAge returns a Criterion which matches if the user authenticated within
+// a maximum age.
+func AuthAge(generator *Generator) Criterion {
+	return authAgeCriterion{g: generator}
+}
+
+func init() {
+	Register(AuthAge)
+}
@@ -0,0 +1,62 @@
+package criteria
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpc/session"
+)
+
+func TestAuthAge(t *testing.T) {
+	issued := func(d time.Duration) []*databroker.Record {
+		return []*databroker.Record{
+			makeRecord(&session.Session{
+				Id:       "SESSION_ID",
+				UserId:   "USER_ID",
+				IssuedAt: timestamppb.New(testingNow.Add(-d)),
+			}),
+		}
+	}
+
+	for _, tc := range []struct {
+		label    string
+		records  []*databroker.Record
+		expected A
+	}{
+		{"recent", issued(time.Minute), A{true, A{ReasonAuthAgeOK}, M{}}},
+		{"at max", issued(5 * time.Minute), A{true, A{ReasonAuthAgeOK}, M{}}},
+		{"stale", issued(5*time.Minute + time.Second), A{false, A{ReasonAuthAgeUnauthorized}, M{}}},
+		{"not issued", []*databroker.Record{makeRecord(&session.Session{Id: "SESSION_ID"})},
+			A{false, A{ReasonAuthAgeUnauthorized}, M{}}},
+		{"no session", nil, A{false, A{ReasonAuthAgeUnauthorized}, M{}}},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - auth_age:
+        max: 5m
+`, tc.records, Input{Session: InputSession{ID: "SESSION_ID"}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`auth_age: 5m`,
+			`auth_age: {max: 300}`,
+			`auth_age: {max: "5 minutes"}`,
+			`auth_age: {max: "-5m"}`,
+			`auth_age: {min: "5m"}`,
+			`auth_age: {}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
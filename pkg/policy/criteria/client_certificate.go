@@ -1,25 +1,41 @@
 package criteria
 
 import (
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/open-policy-agent/opa/ast"
 
 	"github.com/pomerium/pomerium/pkg/policy/generator"
 	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
 )
 
+// The leaf may be a PEM bundle of the client certificate and (some of) its
+// chain, in any order, so the certificate matched is the first end-entity
+// (non-CA) certificate in the bundle. If there isn't one, e.g. for a
+// self-signed CA certificate, the first certificate is matched instead.
 var clientCertificateBaseBody = ast.MustParseBody(`
-	cert := crypto.x509.parse_certificates(trim_space(input.http.client_certificate.leaf))[0]
+	certs := crypto.x509.parse_certificates(trim_space(input.http.client_certificate.leaf))
+	cert := array.concat([bundle_cert | bundle_cert := certs[bundle_index]; not bundle_cert.IsCA], certs)[0]
 	fingerprint := crypto.sha256(base64.decode(cert.Raw))
 	spki_hash := base64.encode(hex.decode(
 		crypto.sha256(base64.decode(cert.RawSubjectPublicKeyInfo))))
 `)
 
+// A presented certificate which can't be parsed is reported with a dedicated
+// reason, rather than simply not matching. The parse error is contained in a
+// comprehension, since an error would otherwise make the whole body undefined.
+var clientCertificateUnparseableBody = ast.MustParseBody(`
+	leaf := trim_space(object.get(input.http.client_certificate, "leaf", ""))
+	leaf != ""
+	count([c | c := crypto.x509.parse_certificates(leaf)[_]]) == 0
+`)
+
 type clientCertificateCriterion struct {
 	g *Generator
 }
@@ -32,100 +48,870 @@ func (clientCertificateCriterion) Name() string {
 	return "client_certificate"
 }
 
+// Reasons returns the reasons the criterion may return. A reason templated
+// with reason_fields has one of these as its prefix.
+func (clientCertificateCriterion) Reasons() Reasons {
+	return NewReasons(
+		ReasonClientCertificateOK,
+		ReasonClientCertificateUnauthorized,
+		ReasonClientCertificateUnparseable,
+		ReasonClientCertificateWarning,
+	)
+}
+
+// GenerateRule generates the rule for a certificate matcher.
+//
+// The matcher is either a single object of conditions, all of which must
+// match, or an array of such objects, any of which must match. The is_not
+// operator on a SAN condition is an explicit deny: a certificate with a SAN
+// equal to the value is rejected, even if it also matches another branch of
+// the matcher.
+//
+// A matcher may instead be negated, like:
+//
+//	not:
+//	  san_dns: {ends_with: .contractors.example.com}
+//
+// which matches a presented certificate that the inner matcher would reject.
+// Unlike the not operator of a policy, it doesn't match a request without a
+// certificate, and it doesn't invert the fail-closed unparseable result. The
+// result of a negated matcher never has the inner matcher's reasons: it's
+// either client-certificate-ok or client-certificate-unauthorized (or
+// client-certificate-unparseable), so the inner matcher may not use warn or
+// reason_fields.
+//
+// Generated rules are cached, keyed by the matcher and the Generator's
+// settings, and renamed for the Generator on a cache hit. Rules for matchers
+// which read fingerprint files aren't cached, so that the files are read
+// each time.
+//
+// A list of values in the matcher, like fingerprints, may have at most the
+// Generator's MaxConditionValues values, so that a misconfigured matcher
+// doesn't generate a huge rule.
 func (c clientCertificateCriterion) GenerateRule(
 	_ string, data parser.Value,
 ) (*ast.Rule, []*ast.Rule, error) {
-	body := append(ast.Body(nil), clientCertificateBaseBody...)
+	// rules which read files can't be cached, since the files may change
+	cacheable := !certMatcherReadsFiles(data)
+	key := newCertRuleCacheKey(c.g, data)
+	if rule, additionalRules, ok := certRules.get(key); ok && cacheable {
+		return c.g.NewRuleFromTemplate(c.Name(), rule), additionalRules, nil
+	}
 
-	obj, ok := data.(parser.Object)
-	if !ok {
-		return nil, nil, fmt.Errorf("expected object for certificate matcher, got: %T", data)
+	if err := checkCertMatcherValues(data, c.g.MaxConditionValues()); err != nil {
+		return nil, nil, err
 	}
 
-	for k, v := range obj {
-		var err error
+	branches, negated, err := parseCertMatcher(data)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		switch k {
-		case "fingerprint":
-			err = addCertFingerprintCondition(&body, v)
-		case "spki_hash":
-			err = addCertSPKIHashCondition(&body, v)
-		case "san_email":
-			err = matchString(&body, ast.VarTerm("cert.EmailAddresses[_]"), v)
-		case "san_dns":
-			err = matchString(&body, ast.VarTerm("cert.DNSNames[_]"), v)
-		case "san_uri":
-			err = matchString(&body, ast.VarTerm("cert.URIStrings[_]"), v)
-		default:
-			err = fmt.Errorf("unsupported certificate matcher condition: %s", k)
+	var allow []certMatcherBranch
+	var deny []ast.Body
+	for _, src := range branches {
+		b, err := generateCertMatcherBranch(c.g, src, &deny)
+		if err != nil {
+			return nil, nil, err
+		}
+		allow = append(allow, b)
+	}
+
+	var rule *ast.Rule
+	if negated {
+		rule = newNegatedCertificateRule(c.g, c.Name(), allow, deny)
+	} else {
+		rule = newCertificateRule(c.g, c.Name(), allow, deny)
+	}
+
+	var additionalRules []*ast.Rule
+	for _, b := range allow {
+		if b.usesSession {
+			additionalRules = []*ast.Rule{
+				rules.GetSession(),
+				rules.GetUser(),
+				rules.GetUserEmail(),
+			}
+			break
+		}
+	}
+	allowedSets := ast.NewRuleSet()
+	for _, b := range allow {
+		for _, r := range b.allowedSets {
+			allowedSets.Add(r)
+		}
+	}
+	additionalRules = append(additionalRules, allowedSets...)
+
+	if cacheable {
+		certRules.set(key, rule, additionalRules)
+	}
+
+	return rule, additionalRules, nil
+}
+
+// certMatcherReadsFiles returns true if a certificate matcher has a fingerprint
+// file condition. Since only fingerprint conditions use a file operator, any
+// object with one is assumed to be one.
+func certMatcherReadsFiles(data parser.Value) bool {
+	switch v := data.(type) {
+	case parser.Object:
+		if _, ok := v["file"]; ok {
+			return true
+		}
+		for _, e := range v {
+			if certMatcherReadsFiles(e) {
+				return true
+			}
+		}
+	case parser.Array:
+		for _, e := range v {
+			if certMatcherReadsFiles(e) {
+				return true
+			}
 		}
+	}
+	return false
+}
+
+// ValidateCertificateMatcher checks that a certificate matcher is well-formed
+// without generating any rego. It returns the same errors as the
+// client_certificate criterion's GenerateRule, except that fingerprint pin
+// references are not resolved and fingerprint files are not read. The options
+// are those of the Generator, of which only WithStrictValues applies.
+func ValidateCertificateMatcher(data parser.Value, options ...generator.Option) error {
+	g := generator.New(options...)
+	branches, _, err := parseCertMatcher(data)
+	if err != nil {
+		return err
+	}
 
+	for _, src := range branches {
+		err := validateCertMatcherBranch(g, src)
 		if err != nil {
-			return nil, nil, err
+			return err
+		}
+	}
+	return nil
+}
+
+// A certMatcherSource is the object of conditions for a branch of a
+// certificate matcher, along with where its conditions came from in the
+// matcher, so that errors can be reported at the offending value.
+type certMatcherSource struct {
+	obj parser.Object
+	// path are the keys of the object within the matcher
+	path []string
+	// keyPaths are the keys within the object of the conditions whose keys
+	// were normalized, or which were expanded from a san any_of sub-condition
+	keyPaths map[string][]string
+}
+
+// keys returns the keys of the condition k within the object.
+func (src certMatcherSource) keys(k string) []string {
+	if p, ok := src.keyPaths[k]; ok {
+		return p
+	}
+	return []string{k}
+}
+
+// errorAt returns err as caused by the value of the condition k.
+func (src certMatcherSource) errorAt(err error, k string) error {
+	return parser.ErrorAt(err, append(slices.Clone(src.path), src.keys(k)...)...)
+}
+
+// normalizeCertMatcherSource lowercases the condition keys of a branch, since
+// operators sometimes write them as they appear elsewhere, like SAN_DNS. Errors
+// are still reported at the keys as written. A condition may not be repeated
+// with different cases.
+func normalizeCertMatcherSource(src certMatcherSource) (certMatcherSource, error) {
+	keys := make([]string, 0, len(src.obj))
+	for k := range src.obj {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	normalized := certMatcherSource{
+		obj:      make(parser.Object, len(src.obj)),
+		path:     src.path,
+		keyPaths: make(map[string][]string),
+	}
+	written := make(map[string]string, len(keys))
+	for _, k := range keys {
+		lk := strings.ToLower(k)
+		if prev, ok := written[lk]; ok {
+			return certMatcherSource{}, src.errorAt(
+				fmt.Errorf("certificate matcher condition %s is repeated as %s", prev, k), k)
+		}
+		written[lk] = k
+		normalized.obj[lk] = src.obj[k]
+		if lk != k {
+			normalized.keyPaths[lk] = src.keys(k)
+		}
+	}
+	return normalized, nil
+}
+
+// checkCertMatcherValues returns an error if any list in a certificate
+// matcher, whether of branches or of the values of a condition, has more than
+// limit values. A limit of 0 or less is no limit.
+func checkCertMatcherValues(data parser.Value, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	switch v := data.(type) {
+	case parser.Array:
+		if len(v) > limit {
+			return fmt.Errorf("certificate matcher list has %d values, more than the limit of %d", len(v), limit)
+		}
+		for i := range v {
+			if err := checkCertMatcherValues(v[i], limit); err != nil {
+				return parser.ErrorAt(err, strconv.Itoa(i))
+			}
+		}
+	case parser.Object:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if err := checkCertMatcherValues(v[k], limit); err != nil {
+				return parser.ErrorAt(err, k)
+			}
 		}
 	}
+	return nil
+}
 
-	rule := NewCriterionRule(c.g, c.Name(),
-		ReasonClientCertificateOK, ReasonClientCertificateUnauthorized,
-		body)
+// parseCertMatcher returns the branches of a certificate matcher, and whether
+// the matcher is negated by a not condition. A negated matcher has no other
+// conditions, and its branches are those of the inner matcher.
+func parseCertMatcher(data parser.Value) ([]certMatcherSource, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		branches, err := certMatcherBranches(data)
+		return branches, false, err
+	}
+
+	key := certMatcherNotKey(obj)
+	if key == "" {
+		branches, err := certMatcherBranches(data)
+		return branches, false, err
+	} else if len(obj) > 1 {
+		return nil, false, parser.ErrorAt(
+			errors.New("certificate matcher not may not be combined with other conditions"), key)
+	}
 
-	return rule, nil, nil
+	branches, err := certMatcherBranches(obj[key])
+	if err != nil {
+		return nil, false, parser.ErrorAt(err, key)
+	}
+	for i, src := range branches {
+		for _, k := range []string{"warn", "reason_fields"} {
+			if _, ok := src.obj[k]; ok {
+				return nil, false, parser.ErrorAt(
+					src.errorAt(fmt.Errorf("certificate matcher not does not support %s", k), k), key)
+			}
+		}
+		branches[i].path = append([]string{key}, src.path...)
+	}
+	return branches, true, nil
 }
 
-func addCertFingerprintCondition(body *ast.Body, data parser.Value) error {
+// certMatcherNotKey returns the key of the not condition of a certificate
+// matcher, as written, or "" if it isn't negated.
+func certMatcherNotKey(obj parser.Object) string {
+	for k := range obj {
+		if strings.ToLower(k) == "not" {
+			return k
+		}
+	}
+	return ""
+}
+
+// certMatcherBranches returns the branches of a certificate matcher, which is
+// either a single object or a non-empty array of objects. A branch with a san
+// any_of condition is expanded into one branch per sub-condition.
+func certMatcherBranches(data parser.Value) ([]certMatcherSource, error) {
 	var pa parser.Array
 	switch v := data.(type) {
+	case parser.Object:
+		src, err := normalizeCertMatcherSource(certMatcherSource{obj: v})
+		if err != nil {
+			return nil, err
+		}
+		return expandCertSANAnyOf([]certMatcherSource{src})
 	case parser.Array:
+		if len(v) == 0 {
+			return nil, errors.New("certificate matcher array must not be empty")
+		}
 		pa = v
-	case parser.String:
-		pa = parser.Array{data}
 	default:
-		return errors.New("certificate fingerprint condition expects a string or array of strings")
+		return nil, fmt.Errorf("expected object for certificate matcher, got: %T", data)
 	}
 
-	ra := ast.NewArray()
-	for _, v := range pa {
-		f, err := canonicalCertFingerprint(v)
+	branches := make([]certMatcherSource, 0, len(pa))
+	for i, v := range pa {
+		obj, ok := v.(parser.Object)
+		if !ok {
+			return nil, parser.ErrorAt(
+				fmt.Errorf("expected object for certificate matcher, got: %T", v), strconv.Itoa(i))
+		}
+		src, err := normalizeCertMatcherSource(certMatcherSource{obj: obj, path: []string{strconv.Itoa(i)}})
 		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, src)
+	}
+	return expandCertSANAnyOf(branches)
+}
+
+// certSANAnyOfConditions maps the SAN types of a san any_of sub-condition to
+// the equivalent certificate matcher condition.
+var certSANAnyOfConditions = map[string]string{
+	"dns":   "san_dns",
+	"email": "san_email",
+	"uri":   "san_uri",
+}
+
+// expandCertSANAnyOf expands the san any_of condition of each branch, like:
+//
+//	san:
+//	  any_of:
+//	    - dns: {ends_with: .example.com}
+//	    - email: {ends_with: "@example.com"}
+//
+// into one branch per sub-condition, each with the other conditions of the
+// branch, so that any one of the sub-conditions may match. The conditions of a
+// sub-condition with more than one SAN type must all match.
+func expandCertSANAnyOf(branches []certMatcherSource) ([]certMatcherSource, error) {
+	var expanded []certMatcherSource
+	for _, src := range branches {
+		v, ok := src.obj["san"]
+		if !ok {
+			expanded = append(expanded, src)
+			continue
+		}
+
+		subConditions, err := parseCertSANAnyOf(v)
+		if err != nil {
+			return nil, src.errorAt(err, "san")
+		}
+
+		for i, sub := range subConditions {
+			branch := certMatcherSource{
+				obj:      src.obj.Clone().(parser.Object),
+				path:     src.path,
+				keyPaths: make(map[string][]string),
+			}
+			for k, p := range src.keyPaths {
+				branch.keyPaths[k] = p
+			}
+			delete(branch.obj, "san")
+			for k, v := range sub {
+				condition := certSANAnyOfConditions[strings.ToLower(k)]
+				branch.keyPaths[condition] = append(slices.Clone(src.keys("san")), "any_of", strconv.Itoa(i), k)
+				if _, ok := branch.obj[condition]; ok {
+					return nil, branch.errorAt(
+						fmt.Errorf("certificate san any_of %s condition conflicts with %s", k, condition), condition)
+				}
+				branch.obj[condition] = v
+			}
+			expanded = append(expanded, branch)
+		}
+	}
+	return expanded, nil
+}
+
+// parseCertSANAnyOf returns the sub-conditions of a san any_of condition.
+func parseCertSANAnyOf(data parser.Value) ([]parser.Object, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, fmt.Errorf("expected object for certificate san condition, got: %T", data)
+	}
+	for k := range obj {
+		if k != "any_of" {
+			return nil, fmt.Errorf("unsupported certificate san condition: %s", k)
+		}
+	}
+
+	pa, ok := obj["any_of"].(parser.Array)
+	if !ok {
+		return nil, parser.ErrorAt(errors.New("certificate san any_of expects an array of objects"), "any_of")
+	} else if len(pa) == 0 {
+		return nil, parser.ErrorAt(errors.New("certificate san any_of must not be empty"), "any_of")
+	}
+
+	subConditions := make([]parser.Object, 0, len(pa))
+	for i, v := range pa {
+		sub, ok := v.(parser.Object)
+		if !ok {
+			return nil, parser.ErrorAt(
+				fmt.Errorf("expected object for certificate san any_of condition, got: %T", v),
+				"any_of", strconv.Itoa(i))
+		} else if len(sub) == 0 {
+			return nil, parser.ErrorAt(
+				errors.New("certificate san any_of condition must not be empty"),
+				"any_of", strconv.Itoa(i))
+		}
+		for k := range sub {
+			if _, ok := certSANAnyOfConditions[strings.ToLower(k)]; !ok {
+				return nil, parser.ErrorAt(
+					fmt.Errorf("unsupported certificate san any_of condition: %s", k),
+					"any_of", strconv.Itoa(i), k)
+			}
+		}
+		subConditions = append(subConditions, sub)
+	}
+	return subConditions, nil
+}
+
+// A certCondition is a condition of a certificate matcher branch, other than
+// warn, which nests the other conditions.
+type certCondition struct {
+	// validate returns the error add would return for the value, without
+	// the settings of the generator, like pins and network zones, which
+	// can't be resolved by ValidateCertificateMatcher.
+	validate func(data parser.Value) error
+	// add adds the condition to the branch, along with any deny bodies.
+	add func(g *Generator, b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error
+}
+
+// certConditions are the conditions of a certificate matcher branch, by key.
+var certConditions = map[string]certCondition{
+	"fingerprint": {
+		validate: validateCertFingerprintMatcher,
+		add: func(g *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertFingerprintCondition(b, data, g.LookupPin)
+		},
+	},
+	"pem_fingerprint": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertSHA256Fingerprints(data, "PEM fingerprint")
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertPEMFingerprintCondition(b, data)
+		},
+	},
+	"spki_hash": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertSPKIHashes(data)
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertSPKIHashCondition(b, data)
+		},
+	},
+	"issuer_fingerprint": {
+		validate: validateCertIssuerFingerprintMatcher,
+		add: func(g *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertIssuerFingerprintCondition(b, data, g.TrustBundleFingerprints)
+		},
+	},
+	"root_fingerprint": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertSHA256Fingerprints(data, "root fingerprint")
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertRootFingerprintCondition(b, data)
+		},
+	},
+	"san_email": {
+		validate: validateCertSANEmailMatcher,
+		add: func(_ *Generator, b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+			return addCertSANEmailCondition(b, deny, data)
+		},
+	},
+	"san_dns": {
+		validate: validateCertSANDNSMatcher,
+		add: func(_ *Generator, b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+			return addCertSANDNSCondition(b, deny, data)
+		},
+	},
+	"san_uri": {
+		validate: validateCertSANURIMatcher,
+		add: func(_ *Generator, b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+			return addCertSANURICondition(b, deny, data)
+		},
+	},
+	"san_ip": {
+		validate: func(data parser.Value) error {
+			_, _, err := parseCertSANIPMatcher(data)
 			return err
+		},
+		add: func(g *Generator, b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+			return addCertSANIPCondition(b, deny, data, g.LookupNetworkZone)
+		},
+	},
+	"san_denylist": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertSANDenylistNames(data)
+			return err
+		},
+		add: func(g *Generator, b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+			return addCertSANDenylistCondition(b, deny, data, g.LookupSANDenylist)
+		},
+	},
+	"subject": {
+		validate: validateCertSubjectMatcher,
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertSubjectCondition(&b.body, data)
+		},
+	},
+	"subject_dn": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertSubjectDN(data)
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertSubjectDNCondition(&b.body, data)
+		},
+	},
+	"aia_ocsp_host": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertAIAHosts(data, "aia_ocsp_host", "OCSP host")
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertAIAOCSPHostCondition(b, data)
+		},
+	},
+	"aia_ca_issuers_host": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertAIAHosts(data, "aia_ca_issuers_host", "CA issuers host")
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return addCertAIACAIssuersHostCondition(b, data)
+		},
+	},
+	"key_usage":          certBodyCondition(validateCertKeyUsageMatcher, addCertKeyUsageCondition),
+	"extended_key_usage": certBodyCondition(validateCertExtKeyUsageMatcher, addCertExtKeyUsageCondition),
+	"issued_after": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertIssuedAfter(data)
+		return err
+	}, addCertIssuedAfterCondition),
+	"expires_within": certBodyCondition(func(data parser.Value) error {
+		_, _, err := parseCertExpiresWithinCondition(data)
+		return err
+	}, addCertExpiresWithinCondition),
+	"issued_within": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertWindow("issued_within", data)
+		return err
+	}, addCertIssuedWithinCondition),
+	"max_validity": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertWindow("max_validity", data)
+		return err
+	}, addCertMaxValidityCondition),
+	"self_signed": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertSelfSigned(data)
+		return err
+	}, addCertSelfSignedCondition),
+	"require_crl_dp": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertRequireCRLDP(data)
+		return err
+	}, addCertRequireCRLDPCondition),
+	"require_sct": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertRequireSCT(data)
+		return err
+	}, addCertRequireSCTCondition),
+	"san_raw": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertSANRaw(data)
+		return err
+	}, addCertSANRawCondition),
+	"max_total_san": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertMaxTotalSAN(data)
+		return err
+	}, addCertMaxTotalSANCondition),
+	"ip_only": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertIPOnly(data)
+		return err
+	}, addCertIPOnlyCondition),
+	"serial_number": certBodyCondition(func(data parser.Value) error {
+		_, _, err := parseCertSerialNumberMod(data)
+		return err
+	}, addCertSerialNumberCondition),
+	"issuer_serial": {
+		validate: func(data parser.Value) error {
+			_, err := parseCertIssuerSerial(data)
+			return err
+		},
+		add: addCertIssuerSerialCondition,
+	},
+	"signature_scheme": certBodyCondition(func(data parser.Value) error {
+		_, err := parseCertSignatureSchemes(data)
+		return err
+	}, addCertSignatureSchemeCondition),
+	"reason_fields": {
+		validate: func(data parser.Value) error {
+			_, err := certReasonFields(data)
+			return err
+		},
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			// not a condition, the reason is added once the body is complete
+			fields, err := certReasonFields(data)
+			b.reasonFields = fields
+			return err
+		},
+	},
+}
+
+// certBodyCondition returns a condition which only adds expressions to the
+// body of the branch.
+func certBodyCondition(
+	validate func(parser.Value) error, add func(*ast.Body, parser.Value) error,
+) certCondition {
+	return certCondition{
+		validate: validate,
+		add: func(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+			return add(&b.body, data)
+		},
+	}
+}
+
+// A certMatcherBranch is a single candidate body for a certificate matcher.
+type certMatcherBranch struct {
+	body ast.Body
+	// reason is the (templated) reason to use when the body matches. If nil
+	// the default reason is used.
+	reason *ast.Term
+	// matchedSANs are the SAN types with a matching SAN bound by the body.
+	matchedSANs []certSAN
+	// usesSession is true if the body references the session user's email.
+	usesSession bool
+	// allowedSets are the rules defining the sets of allowed (or denied)
+	// values, and the helper functions, referenced by the body, or by the
+	// deny bodies it added.
+	allowedSets []*ast.Rule
+	// warn are the soft conditions of the body, if any. The body matches
+	// whether or not they do, but with a warning if they don't.
+	warn *certMatcherBranch
+	// reasonFields are the fields of the templated reason, if any. They're
+	// added once the body is complete.
+	reasonFields []ReasonField
+}
+
+func generateCertMatcherBranch(g *Generator, src certMatcherSource, deny *[]ast.Body) (certMatcherBranch, error) {
+	b := certMatcherBranch{
+		body: append(ast.Body(nil), clientCertificateBaseBody...),
+	}
+
+	for k, v := range src.obj {
+		var err error
+
+		if certCommentedConditions[k] && !g.StrictValues() {
+			v = stripCertValueComments(v)
+		}
+		if certSANConditions[k] && !g.StrictValues() {
+			v = tidyCertSANValues(v)
+		}
+
+		if k == "warn" {
+			err = addCertWarnConditions(g, &b, v, src.obj)
+		} else if c, ok := certConditions[k]; ok {
+			err = c.add(g, &b, deny, v)
+		} else {
+			err = fmt.Errorf("unsupported certificate matcher condition: %s", k)
+		}
+
+		if err != nil {
+			return certMatcherBranch{}, src.errorAt(err, k)
 		}
-		ra = ra.Append(ast.NewTerm(f))
 	}
 
-	*body = append(*body,
-		ast.Assign.Expr(ast.VarTerm("allowed_fingerprints"), ast.NewTerm(ra)),
-		ast.Equal.Expr(ast.VarTerm("fingerprint"), ast.VarTerm("allowed_fingerprints[_]")))
-	return nil
+	if b.reasonFields != nil {
+		b.body = append(b.body,
+			NewTemplatedReasonExpr(ast.VarTerm("reason"), ReasonClientCertificateOK, b.reasonFields...))
+		b.reason = ast.VarTerm("reason")
+	}
+	return b, nil
 }
 
-// The long certificate fingerprint format is 32 uppercase hex-encoded bytes
-// separated by colons.
-var longCertFingerprintRE = regexp.MustCompile("^[0-9A-F]{2}(:[0-9A-F]{2}){31}$")
+// addCertWarnConditions adds the soft conditions of a warn condition to the
+// branch, like:
+//
+//	fingerprint: 17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704
+//	warn:
+//	  issued_after: "2024-01-15T00:00:00Z"
+//
+// A certificate which matches the other conditions of the branch matches
+// whether or not it matches the soft conditions, so they never deny access.
+// If it doesn't match them, the client-certificate-warning reason is added to
+// the result, e.g. so that the certificates which a phased rollout would
+// reject can be found from the authorization logs before it's enforced.
+func addCertWarnConditions(g *Generator, b *certMatcherBranch, data parser.Value, branch parser.Object) error {
+	src, err := parseCertWarnConditions(data, branch)
+	if err != nil {
+		return err
+	}
 
-// The short certificate fingerprint format is 32 lowercase hex-encoded bytes.
-var shortCertFingerprintRE = regexp.MustCompile("^[0-9a-f]{64}$")
+	var deny []ast.Body
+	w, err := generateCertMatcherBranch(g, src, &deny)
+	if err != nil {
+		return err
+	}
+	// the base body is already part of the branch
+	w.body = w.body[len(clientCertificateBaseBody):]
 
-// canonicalCertFingeprint converts a single fingerprint value into the format
-// that our Rego logic generates.
-func canonicalCertFingerprint(data parser.Value) (ast.Value, error) {
-	s, ok := data.(parser.String)
+	b.usesSession = b.usesSession || w.usesSession
+	b.allowedSets = append(b.allowedSets, w.allowedSets...)
+	w.allowedSets = nil
+	b.warn = &w
+	return nil
+}
+
+// parseCertWarnConditions returns the soft conditions of a warn condition in
+// the given branch. The soft conditions may not deny access, so is_not and
+// san_denylist aren't supported, nor may they repeat a condition of the branch.
+func parseCertWarnConditions(data parser.Value, branch parser.Object) (certMatcherSource, error) {
+	obj, ok := data.(parser.Object)
 	if !ok {
-		return nil, fmt.Errorf("certificate fingerprint must be a string (was %v)", data)
+		return certMatcherSource{}, fmt.Errorf("expected object for certificate warn conditions, got: %T", data)
+	} else if len(obj) == 0 {
+		return certMatcherSource{}, errors.New("certificate warn conditions must not be empty")
+	}
+
+	src, err := normalizeCertMatcherSource(certMatcherSource{obj: obj})
+	if err != nil {
+		return certMatcherSource{}, err
+	}
+	for k, v := range src.obj {
+		switch k {
+		case "warn", "reason_fields", "san", "san_denylist":
+			return certMatcherSource{}, src.errorAt(fmt.Errorf("unsupported certificate warn condition: %s", k), k)
+		}
+		if _, ok := branch[k]; ok {
+			return certMatcherSource{}, src.errorAt(
+				fmt.Errorf("certificate warn condition %s conflicts with the matcher condition", k), k)
+		}
+		if sub, ok := v.(parser.Object); ok && certSANConditions[k] {
+			if _, ok := sub["is_not"]; ok {
+				return certMatcherSource{}, src.errorAt(
+					fmt.Errorf("certificate warn condition %s does not support is_not", k), k)
+			}
+		}
 	}
+	return src, nil
+}
+
+// validateCertMatcherBranch is the counterpart of generateCertMatcherBranch
+// used by ValidateCertificateMatcher. Both look up the conditions in
+// certConditions.
+func validateCertMatcherBranch(g *Generator, src certMatcherSource) error {
+	for k, v := range src.obj {
+		var err error
+
+		if certCommentedConditions[k] && !g.StrictValues() {
+			v = stripCertValueComments(v)
+		}
+
+		if certSANConditions[k] {
+			if !g.StrictValues() {
+				v = tidyCertSANValues(v)
+			}
+			v, _, err = splitCertSANOptional(v)
+			if err != nil {
+				return src.errorAt(err, k)
+			}
+		}
+
+		if k == "warn" {
+			var warn certMatcherSource
+			warn, err = parseCertWarnConditions(v, src.obj)
+			if err == nil {
+				err = validateCertMatcherBranch(g, warn)
+			}
+		} else if c, ok := certConditions[k]; ok {
+			err = c.validate(v)
+		} else {
+			err = fmt.Errorf("unsupported certificate matcher condition: %s", k)
+		}
+
+		if err != nil {
+			return src.errorAt(err, k)
+		}
+	}
+	return nil
+}
+
+// certCommentedConditions are the conditions whose values may have a trailing
+// comment, e.g. a fingerprint pasted from an operator's notes.
+var certCommentedConditions = map[string]bool{
+	"fingerprint":        true,
+	"pem_fingerprint":    true,
+	"issuer_fingerprint": true,
+	"root_fingerprint":   true,
+	"spki_hash":          true,
+}
+
+// certSANConditions are the conditions which match a type of SAN, and so
+// accept the optional modifier.
+var certSANConditions = map[string]bool{
+	"san_email": true,
+	"san_dns":   true,
+	"san_uri":   true,
+	"san_ip":    true,
+}
+
+// A trailing comment is a # preceded by whitespace.
+var certValueCommentRE = regexp.MustCompile(`\s+#.*$`)
+
+// stripCertValueComments strips any trailing comments from a string or array
+// of strings.
+func stripCertValueComments(data parser.Value) parser.Value {
+	switch v := data.(type) {
+	case parser.String:
+		return parser.String(certValueCommentRE.ReplaceAllString(string(v), ""))
+	case parser.Array:
+		stripped := make(parser.Array, len(v))
+		for i := range v {
+			stripped[i] = stripCertValueComments(v[i])
+		}
+		return stripped
+	}
+	return data
+}
 
-	f := string(s)
-	if f == "" {
-		return nil, errors.New("certificate fingerprint must not be empty")
-	} else if shortCertFingerprintRE.MatchString(f) {
-		return ast.String(f), nil
-	} else if longCertFingerprintRE.MatchString(f) {
-		f = strings.ToLower(strings.ReplaceAll(f, ":", ""))
-		return ast.String(f), nil
+// tidyCertSANValues strips any trailing comments and surrounding whitespace
+// from the string values of a SAN matcher, e.g. a DNS name copied from a
+// certificate dump. Regular expressions are left as they are, since their
+// whitespace is significant. Values which are invalid once tidied, like one
+// of only whitespace, are still rejected.
+func tidyCertSANValues(data parser.Value) parser.Value {
+	switch v := data.(type) {
+	case parser.String:
+		return parser.String(strings.TrimSpace(certValueCommentRE.ReplaceAllString(string(v), "")))
+	case parser.Array:
+		tidied := make(parser.Array, len(v))
+		for i := range v {
+			tidied[i] = tidyCertSANValues(v[i])
+		}
+		return tidied
+	case parser.Object:
+		tidied := make(parser.Object, len(v))
+		for k, e := range v {
+			if k != "matches" {
+				e = tidyCertSANValues(e)
+			}
+			tidied[k] = e
+		}
+		return tidied
 	}
-	return nil, fmt.Errorf("unsupported certificate fingerprint format (%s)", f)
+	return data
+}
+
+// certReasonFieldLookup contains the certificate fields which may be
+// interpolated into the reason for a successful match.
+var certReasonFieldLookup = map[string]ReasonField{
+	"fingerprint": {Name: "fingerprint", Value: ast.VarTerm("fingerprint")},
+	"subject_cn":  {Name: "CN", Value: ast.MustParseTerm("cert.Subject.CommonName")},
 }
 
-func addCertSPKIHashCondition(body *ast.Body, data parser.Value) error {
+func certReasonFields(data parser.Value) ([]ReasonField, error) {
 	var pa parser.Array
 	switch v := data.(type) {
 	case parser.Array:
@@ -133,31 +919,153 @@ func addCertSPKIHashCondition(body *ast.Body, data parser.Value) error {
 	case parser.String:
 		pa = parser.Array{data}
 	default:
-		return errors.New("certificate SPKI hash condition expects a string or array of strings")
+		return nil, errors.New("certificate reason fields expects a string or array of strings")
 	}
 
-	ra := ast.NewArray()
+	var fields []ReasonField
 	for _, v := range pa {
 		s, ok := v.(parser.String)
 		if !ok {
-			return fmt.Errorf("certificate SPKI hash must be a string (was %v)", v)
+			return nil, fmt.Errorf("certificate reason field must be a string (was %v)", v)
+		}
+		f, ok := certReasonFieldLookup[string(s)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported certificate reason field: %s", string(s))
 		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
 
-		h := string(s)
-		if h == "" {
-			return errors.New("certificate SPKI hash must not be empty")
-		} else if b, err := base64.StdEncoding.DecodeString(h); err != nil || len(b) != 32 {
-			return fmt.Errorf("certificate SPKI hash must be a base64-encoded SHA-256 hash "+
-				"(was %s)", h)
+// newCertificateRule generates a criterion rule from the candidate bodies of a
+// certificate matcher. The deny bodies are evaluated first, so they take
+// precedence over the allow bodies.
+//
+// An unparseable certificate always fails with its own reason. The result is
+// marked fail_closed so that it isn't inverted by a not or nor.
+//
+// When an allow body matches SAN conditions, the first matching SAN of each
+// type is returned in the criterion's additional data, keyed by type:
+//
+//	{"matched_san": {"dns": "www.example.com", "email": "user@example.com"}}
+//
+// An optional SAN condition skipped for a certificate without SANs of its
+// type is returned as null.
+func newCertificateRule(g *Generator, name string, allow []certMatcherBranch, deny []ast.Body) *ast.Rule {
+	candidates := []*ast.Rule{{
+		Head: generator.NewHead("", NewCriterionTermWithAdditionalData(
+			false, ReasonClientCertificateUnparseable, map[string]interface{}{"fail_closed": true})),
+		Body: clientCertificateUnparseableBody,
+	}}
+	for _, body := range deny {
+		candidates = append(candidates, &ast.Rule{
+			Head: generator.NewHead("", NewCriterionTerm(false, ReasonClientCertificateUnauthorized)),
+			Body: body,
+		})
+	}
+	// a branch matching its soft conditions takes precedence over any branch
+	// only matching with a warning
+	for _, b := range allow {
+		body, matchedSANs := b.body, b.matchedSANs
+		if b.warn != nil {
+			body = append(append(ast.Body(nil), b.body...), b.warn.body...)
+			matchedSANs = append(slices.Clone(b.matchedSANs), b.warn.matchedSANs...)
 		}
+		candidates = append(candidates, &ast.Rule{
+			Head: generator.NewHead("", newCertificateAllowHead(b, matchedSANs)),
+			Body: body,
+		})
+	}
+	for _, b := range allow {
+		if b.warn == nil {
+			continue
+		}
+		head := newCertificateAllowHead(b, b.matchedSANs)
+		reasons := head.Value.(*ast.Array).Elem(1).Value.(ast.Set)
+		reasons.Add(ast.StringTerm(ReasonClientCertificateWarning))
+		candidates = append(candidates, &ast.Rule{
+			Head: generator.NewHead("", head),
+			Body: b.body,
+		})
+	}
+	candidates = append(candidates, &ast.Rule{
+		Head: generator.NewHead("", NewCriterionTerm(false, ReasonClientCertificateUnauthorized)),
+		Body: ast.Body{
+			ast.NewExpr(ast.BooleanTerm(true)),
+		},
+	})
 
-		ra = ra.Append(ast.NewTerm(ast.String(h)))
+	rule := g.NewRule(name)
+	rule.Head.Value = candidates[0].Head.Value
+	rule.Body = candidates[0].Body
+	prev := rule
+	for _, candidate := range candidates[1:] {
+		prev.Else = candidate
+		prev = candidate
 	}
+	return rule
+}
 
-	*body = append(*body,
-		ast.Assign.Expr(ast.VarTerm("allowed_spki_hashes"), ast.NewTerm(ra)),
-		ast.Equal.Expr(ast.VarTerm("spki_hash"), ast.VarTerm("allowed_spki_hashes[_]")))
-	return nil
+// newNegatedCertificateRule generates the rule for a negated certificate
+// matcher. It inverts the result of the inner matcher for a presented,
+// parseable certificate: one denied by an is_not operator is allowed, and one
+// matching a branch is denied. Any other certificate is allowed, and no
+// certificate at all is still unauthorized.
+func newNegatedCertificateRule(g *Generator, name string, allow []certMatcherBranch, deny []ast.Body) *ast.Rule {
+	candidates := []*ast.Rule{{
+		Head: generator.NewHead("", NewCriterionTermWithAdditionalData(
+			false, ReasonClientCertificateUnparseable, map[string]interface{}{"fail_closed": true})),
+		Body: clientCertificateUnparseableBody,
+	}}
+	for _, body := range deny {
+		candidates = append(candidates, &ast.Rule{
+			Head: generator.NewHead("", NewCriterionTerm(true, ReasonClientCertificateOK)),
+			Body: body,
+		})
+	}
+	for _, b := range allow {
+		candidates = append(candidates, &ast.Rule{
+			Head: generator.NewHead("", NewCriterionTerm(false, ReasonClientCertificateUnauthorized)),
+			Body: b.body,
+		})
+	}
+	candidates = append(candidates, &ast.Rule{
+		Head: generator.NewHead("", NewCriterionTerm(true, ReasonClientCertificateOK)),
+		Body: clientCertificateBaseBody,
+	}, &ast.Rule{
+		Head: generator.NewHead("", NewCriterionTerm(false, ReasonClientCertificateUnauthorized)),
+		Body: ast.Body{
+			ast.NewExpr(ast.BooleanTerm(true)),
+		},
+	})
+
+	rule := g.NewRule(name)
+	rule.Head.Value = candidates[0].Head.Value
+	rule.Body = candidates[0].Body
+	prev := rule
+	for _, candidate := range candidates[1:] {
+		prev.Else = candidate
+		prev = candidate
+	}
+	return rule
+}
+
+// newCertificateAllowHead returns the result of a matching branch.
+func newCertificateAllowHead(b certMatcherBranch, matchedSANs []certSAN) *ast.Term {
+	reason := ast.StringTerm(ReasonClientCertificateOK)
+	if b.reason != nil {
+		reason = b.reason
+	}
+	head := ast.ArrayTerm(ast.BooleanTerm(true), ast.SetTerm(reason))
+	if len(matchedSANs) > 0 {
+		var kvs [][2]*ast.Term
+		for _, san := range matchedSANs {
+			kvs = append(kvs, [2]*ast.Term{ast.StringTerm(san.name), san.matched()})
+		}
+		head.Value = head.Value.(*ast.Array).Append(ast.ObjectTerm(
+			[2]*ast.Term{ast.StringTerm("matched_san"), ast.ObjectTerm(kvs...)}))
+	}
+	return head
 }
 
 // ClientCertificate returns a Criterion on a client certificate.
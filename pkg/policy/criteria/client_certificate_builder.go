@@ -0,0 +1,107 @@
+package criteria
+
+import (
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// A CertificateMatcherBuilder builds a client_certificate matcher, e.g. for a
+// program generating policies, rather than constructing the parser values by
+// hand:
+//
+//	matcher, err := NewCertificateMatcher().
+//		DNS("host.corp").
+//		Fingerprint("17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704").
+//		Build()
+//
+// Each method sets a condition of the current branch, replacing any earlier
+// value of the same condition. Or starts a new branch.
+type CertificateMatcherBuilder struct {
+	branches []parser.Object
+}
+
+// NewCertificateMatcher returns a builder for a matcher with a single, empty
+// branch.
+func NewCertificateMatcher() *CertificateMatcherBuilder {
+	return &CertificateMatcherBuilder{branches: []parser.Object{{}}}
+}
+
+// Fingerprint requires the certificate's fingerprint to be one of the given
+// fingerprints.
+func (b *CertificateMatcherBuilder) Fingerprint(fingerprints ...string) *CertificateMatcherBuilder {
+	return b.Condition("fingerprint", certMatcherStrings(fingerprints))
+}
+
+// SPKIHash requires the hash of the certificate's public key to be one of the
+// given hashes.
+func (b *CertificateMatcherBuilder) SPKIHash(hashes ...string) *CertificateMatcherBuilder {
+	return b.Condition("spki_hash", certMatcherStrings(hashes))
+}
+
+// DNS requires the certificate to have the DNS SAN name.
+func (b *CertificateMatcherBuilder) DNS(name string) *CertificateMatcherBuilder {
+	return b.Condition("san_dns", parser.Object{"is": parser.String(name)})
+}
+
+// Email requires the certificate to have the email SAN address.
+func (b *CertificateMatcherBuilder) Email(address string) *CertificateMatcherBuilder {
+	return b.Condition("san_email", parser.Object{"is": parser.String(address)})
+}
+
+// URI requires the certificate to have the URI SAN uri.
+func (b *CertificateMatcherBuilder) URI(uri string) *CertificateMatcherBuilder {
+	return b.Condition("san_uri", parser.Object{"is": parser.String(uri)})
+}
+
+// SubjectOU requires the certificate's subject to have exactly the given
+// organizational units, in order.
+func (b *CertificateMatcherBuilder) SubjectOU(ous ...string) *CertificateMatcherBuilder {
+	return b.Condition("subject", parser.Object{"ou": certMatcherStrings(ous)})
+}
+
+// Condition sets any other condition of the current branch, like
+// Condition("self_signed", parser.Boolean(false)).
+func (b *CertificateMatcherBuilder) Condition(name string, value parser.Value) *CertificateMatcherBuilder {
+	b.branches[len(b.branches)-1][name] = value
+	return b
+}
+
+// Or starts a new branch, so that the matcher matches certificates which
+// match any of its branches.
+func (b *CertificateMatcherBuilder) Or() *CertificateMatcherBuilder {
+	b.branches = append(b.branches, parser.Object{})
+	return b
+}
+
+// Build returns the matcher, which is an object for a single branch, or an
+// array of branches, as consumed by the client_certificate criterion. It's an
+// error if the matcher is invalid, e.g. because of a malformed fingerprint.
+func (b *CertificateMatcherBuilder) Build() (parser.Value, error) {
+	var matcher parser.Value
+	if len(b.branches) == 1 {
+		matcher = b.branches[0].Clone()
+	} else {
+		branches := make(parser.Array, 0, len(b.branches))
+		for _, branch := range b.branches {
+			branches = append(branches, branch.Clone())
+		}
+		matcher = branches
+	}
+
+	if err := ValidateCertificateMatcher(matcher); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+// certMatcherStrings returns a single value as a string, and several as an
+// array of strings, like a hand-written matcher.
+func certMatcherStrings(values []string) parser.Value {
+	if len(values) == 1 {
+		return parser.String(values[0])
+	}
+	pa := make(parser.Array, 0, len(values))
+	for _, v := range values {
+		pa = append(pa, parser.String(v))
+	}
+	return pa
+}
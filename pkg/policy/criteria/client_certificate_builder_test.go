@@ -0,0 +1,82 @@
+package criteria
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/format"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+func TestCertificateMatcherBuilder(t *testing.T) {
+	t.Parallel()
+
+	const fp1 = "17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704"
+	const fp2 = "d75dc6e4d1d4a8b8b2a6a6a2b0e0ffb5e1f7bdb5c2a0f9c5b8e4f8a0b5c6d7e8"
+	for _, tc := range []struct {
+		label    string
+		builder  *CertificateMatcherBuilder
+		expected string
+	}{
+		{"fingerprint", NewCertificateMatcher().Fingerprint(fp1),
+			`{"fingerprint": "` + fp1 + `"}`},
+		{"fingerprints", NewCertificateMatcher().Fingerprint(fp1, fp2),
+			`{"fingerprint": ["` + fp1 + `", "` + fp2 + `"]}`},
+		{"SANs", NewCertificateMatcher().DNS("host.corp").Email("alice@corp.com").URI("spiffe://corp/svc"),
+			`{"san_dns": {"is": "host.corp"}, "san_email": {"is": "alice@corp.com"}, "san_uri": {"is": "spiffe://corp/svc"}}`},
+		{"DNS and fingerprint", NewCertificateMatcher().DNS("host.corp").Fingerprint(fp1),
+			`{"san_dns": {"is": "host.corp"}, "fingerprint": "` + fp1 + `"}`},
+		{"subject", NewCertificateMatcher().SubjectOU("eng", "backend"),
+			`{"subject": {"ou": ["eng", "backend"]}}`},
+		{"condition", NewCertificateMatcher().Condition("self_signed", parser.Boolean(false)).DNS("host.corp"),
+			`{"self_signed": false, "san_dns": {"is": "host.corp"}}`},
+		{"replaced", NewCertificateMatcher().DNS("a.corp").DNS("b.corp"),
+			`{"san_dns": {"is": "b.corp"}}`},
+		{"branches", NewCertificateMatcher().Fingerprint(fp1).Or().DNS("host.corp").SPKIHash("FJeNWbvDvBBmS5Wfd9jItk1K1eUWIdSXlcB1mgNFHHo="),
+			`[{"fingerprint": "` + fp1 + `"}, {"san_dns": {"is": "host.corp"}, "spki_hash": "FJeNWbvDvBBmS5Wfd9jItk1K1eUWIdSXlcB1mgNFHHo="}]`},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			built, err := tc.builder.Build()
+			require.NoError(t, err)
+			expected, err := parser.ParseValue(strings.NewReader(tc.expected))
+			require.NoError(t, err)
+			assert.Equal(t, expected, built)
+
+			rule, additionalRules, err := ClientCertificate(generator.New()).GenerateRule("", built)
+			require.NoError(t, err)
+			expectedRule, expectedAdditionalRules, err := ClientCertificate(generator.New()).GenerateRule("", expected)
+			require.NoError(t, err)
+			assert.Equal(t, string(format.MustAst(expectedRule)), string(format.MustAst(rule)))
+			assert.Equal(t, len(expectedAdditionalRules), len(additionalRules))
+			for i := range additionalRules {
+				assert.Equal(t, string(format.MustAst(expectedAdditionalRules[i])), string(format.MustAst(additionalRules[i])))
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewCertificateMatcher().Fingerprint("sha512:abcd").Build()
+		assert.Error(t, err)
+		_, err = NewCertificateMatcher().DNS("host.corp").Or().Condition("unknown", parser.String("value")).Build()
+		assert.Error(t, err)
+	})
+
+	t.Run("independent", func(t *testing.T) {
+		t.Parallel()
+
+		b := NewCertificateMatcher().DNS("a.corp")
+		first, err := b.Build()
+		require.NoError(t, err)
+		b.DNS("b.corp")
+		assert.Equal(t, parser.Object{"san_dns": parser.Object{"is": parser.String("a.corp")}}, first)
+	})
+}
@@ -0,0 +1,89 @@
+package criteria
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// maxCertRuleCacheEntries bounds the size of the certificate rule cache. When
+// it's full the cache is emptied, which is simpler than tracking usage and
+// fine for the expected number of distinct certificate matchers.
+const maxCertRuleCacheEntries = 1024
+
+// certRules caches the rules generated for certificate matchers, so that
+// reloading a config which changes only a few routes doesn't regenerate the
+// rules of every route.
+var certRules = newCertRuleCache()
+
+type certRuleCacheKey [sha256.Size]byte
+
+type certRuleCacheEntry struct {
+	rule            *ast.Rule
+	additionalRules []*ast.Rule
+}
+
+// A certRuleCache is a cache of generated certificate rules which is safe to
+// use from multiple goroutines. Rules are copied in and out of the cache, so
+// callers are free to modify them.
+type certRuleCache struct {
+	mu      sync.Mutex
+	entries map[certRuleCacheKey]certRuleCacheEntry
+}
+
+func newCertRuleCache() *certRuleCache {
+	return &certRuleCache{entries: make(map[certRuleCacheKey]certRuleCacheEntry)}
+}
+
+// newCertRuleCacheKey returns the cache key for a certificate matcher. Since
+// pins and denylists are resolved during generation, the key includes the
+// Generator's settings.
+func newCertRuleCacheKey(g *Generator, data parser.Value) certRuleCacheKey {
+	settings := g.SettingsHash()
+	h := sha256.New()
+	h.Write(settings[:])
+	// rego objects are printed with sorted keys, so this is stable
+	h.Write([]byte(data.RegoValue().String()))
+
+	var key certRuleCacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+func (c *certRuleCache) get(key certRuleCacheKey) (*ast.Rule, []*ast.Rule, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+	return entry.rule.Copy(), copyRules(entry.additionalRules), true
+}
+
+func (c *certRuleCache) set(key certRuleCacheKey, rule *ast.Rule, additionalRules []*ast.Rule) {
+	entry := certRuleCacheEntry{
+		rule:            rule.Copy(),
+		additionalRules: copyRules(additionalRules),
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCertRuleCacheEntries {
+		c.entries = make(map[certRuleCacheKey]certRuleCacheEntry)
+	}
+	c.entries[key] = entry
+	c.mu.Unlock()
+}
+
+func copyRules(rules []*ast.Rule) []*ast.Rule {
+	if rules == nil {
+		return nil
+	}
+	cp := make([]*ast.Rule, len(rules))
+	for i, r := range rules {
+		cp[i] = r.Copy()
+	}
+	return cp
+}
@@ -0,0 +1,82 @@
+package criteria
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+func TestCertRuleCache(t *testing.T) {
+	t.Parallel()
+
+	// a matcher unique to this test, so other tests don't populate the cache
+	data := parser.Object{
+		"spki_hash": parser.String("n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg="),
+		"san_email": parser.Object{"ends_with": parser.String("@cache.example.com")},
+	}
+	key := newCertRuleCacheKey(generator.New(), data)
+	_, _, ok := certRules.get(key)
+	require.False(t, ok)
+
+	rule1, _, err := ClientCertificate(generator.New()).GenerateRule("", data)
+	require.NoError(t, err)
+
+	cached, _, ok := certRules.get(key)
+	require.True(t, ok, "should cache the generated rule")
+	assert.Equal(t, rule1.String(), cached.String())
+
+	// a cache hit is named for the generator
+	g := generator.New()
+	g.NewRule("client_certificate")
+	rule2, _, err := ClientCertificate(g).GenerateRule("", data)
+	require.NoError(t, err)
+	assert.Equal(t, ast.Var("client_certificate_0"), rule1.Head.Name)
+	assert.Equal(t, ast.Var("client_certificate_1"), rule2.Head.Name)
+	rule2.Head.Name = rule1.Head.Name
+	assert.Equal(t, rule1.String(), rule2.String())
+
+	// the key depends on the generator settings and the exact value
+	assert.NotEqual(t, key, newCertRuleCacheKey(generator.New(generator.WithStrictValues()), data))
+	assert.NotEqual(t, newCertRuleCacheKey(generator.New(), parser.Object{"san_dns": parser.Null{}}),
+		newCertRuleCacheKey(generator.New(), parser.Object{"san_dns": parser.Object{}}))
+	assert.Equal(t, key, newCertRuleCacheKey(generator.New(), data.Clone()))
+
+	// errors aren't cached
+	invalid := parser.Object{"san_dns": parser.Null{}}
+	for i := 0; i < 2; i++ {
+		_, _, err = ClientCertificate(generator.New()).GenerateRule("", invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestCertRuleCacheConcurrency(t *testing.T) {
+	t.Parallel()
+
+	data := parser.Object{
+		"san_dns": parser.Object{"is": parser.String("concurrent.cache.example.com")},
+	}
+
+	var wg sync.WaitGroup
+	rules := make([]string, 16)
+	for i := range rules {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rule, _, err := ClientCertificate(generator.New()).GenerateRule("", data)
+			if assert.NoError(t, err) {
+				rules[i] = rule.String()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, rule := range rules {
+		assert.Equal(t, rules[0], rule)
+	}
+}
@@ -0,0 +1,170 @@
+package criteria
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// CanonicalizeMatcher returns a canonical JSON serialization of a valid
+// certificate matcher, e.g. so that a GitOps pipeline can hash a route's
+// client_certificate criterion to detect real changes. Matchers which the
+// client_certificate criterion reads the same way serialize the same way:
+//
+//   - object keys are sorted, and condition keys are lowercase
+//   - a single branch in an array is the branch itself
+//   - trailing comments, and the whitespace around SAN values, are removed
+//   - fingerprints are in their canonical form, like the generated rules, and
+//     SPKI hashes are re-encoded, and both are sorted lists without duplicates
+//
+// Branches are otherwise kept in order, and values as written, so the same
+// SAN in a different case is a change. Like ValidateCertificateMatcher, pins
+// are left unresolved.
+func CanonicalizeMatcher(data parser.Value) ([]byte, error) {
+	if err := ValidateCertificateMatcher(data); err != nil {
+		return nil, err
+	}
+
+	canonical, err := canonicalCertMatcher(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(canonical)
+}
+
+// canonicalCertMatcher returns the canonical form of a valid matcher.
+func canonicalCertMatcher(data parser.Value) (parser.Value, error) {
+	if obj, ok := data.(parser.Object); ok {
+		if key := certMatcherNotKey(obj); key != "" {
+			inner, err := canonicalCertMatcherBranches(obj[key])
+			if err != nil {
+				return nil, err
+			}
+			return parser.Object{"not": inner}, nil
+		}
+	}
+	return canonicalCertMatcherBranches(data)
+}
+
+// canonicalCertMatcherBranches returns the canonical form of the branches of
+// a valid, non-negated matcher.
+func canonicalCertMatcherBranches(data parser.Value) (parser.Value, error) {
+	branches := certMatcherDiffBranches(data)
+	if len(branches) == 1 {
+		return canonicalCertConditions(branches[0])
+	}
+
+	canonical := make(parser.Array, 0, len(branches))
+	for _, branch := range branches {
+		obj, err := canonicalCertConditions(branch)
+		if err != nil {
+			return nil, err
+		}
+		canonical = append(canonical, obj)
+	}
+	return canonical, nil
+}
+
+// canonicalCertConditions returns the canonical form of the conditions of a
+// branch, or of its warn condition.
+func canonicalCertConditions(obj parser.Object) (parser.Object, error) {
+	canonical := make(parser.Object, len(obj))
+	for k, v := range obj {
+		k = strings.ToLower(k)
+
+		if certCommentedConditions[k] {
+			v = stripCertValueComments(v)
+		}
+		if certSANConditions[k] {
+			v = tidyCertSANValues(v)
+		}
+
+		var err error
+		switch k {
+		case "fingerprint":
+			v, err = canonicalCertFingerprintList(v, true)
+		case "pem_fingerprint", "issuer_fingerprint", "root_fingerprint":
+			v, err = canonicalCertFingerprintList(v, false)
+		case "spki_hash":
+			v, err = canonicalCertSPKIHashList(v)
+		case "warn":
+			if warn, ok := v.(parser.Object); ok {
+				v, err = canonicalCertConditions(warn)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		canonical[k] = v
+	}
+	return canonical, nil
+}
+
+// canonicalCertFingerprintList returns the canonical fingerprints of a list
+// of fingerprints, or of a single one. Other forms, like from_data, are
+// returned as is. Pin references are kept if allowed.
+func canonicalCertFingerprintList(data parser.Value, allowPins bool) (parser.Value, error) {
+	return canonicalCertList(data, func(s parser.String) (string, error) {
+		if allowPins && certFingerprintPinRE.MatchString(string(s)) {
+			return string(s), nil
+		}
+		f, err := canonicalCertFingerprint(s)
+		if err != nil {
+			return "", err
+		}
+		return string(f.(ast.String)), nil
+	})
+}
+
+// canonicalCertSPKIHashList returns the re-encoded SPKI hashes of a list of
+// hashes, or of a single one.
+func canonicalCertSPKIHashList(data parser.Value) (parser.Value, error) {
+	return canonicalCertList(data, func(s parser.String) (string, error) {
+		h, err := base64.StdEncoding.DecodeString(string(s))
+		if err != nil {
+			return "", fmt.Errorf("certificate SPKI hash must be base64-encoded (was %s)", string(s))
+		}
+		return base64.StdEncoding.EncodeToString(h), nil
+	})
+}
+
+// canonicalCertList returns the sorted, unique canonical values of a list of
+// strings, or of a single string. Any other value is returned as is.
+func canonicalCertList(data parser.Value, canonicalize func(parser.String) (string, error)) (parser.Value, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{v}
+	default:
+		return data, nil
+	}
+
+	values := make([]string, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got: %T", v)
+		}
+		c, err := canonicalize(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, c)
+	}
+	slices.Sort(values)
+	values = slices.Compact(values)
+
+	canonical := make(parser.Array, 0, len(values))
+	for _, v := range values {
+		canonical = append(canonical, parser.String(v))
+	}
+	return canonical, nil
+}
@@ -0,0 +1,106 @@
+package criteria
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+func TestCanonicalizeMatcher(t *testing.T) {
+	t.Parallel()
+
+	const (
+		fp1     = "17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704"
+		fp1Long = "17:85:92:73:E8:A9:80:63:1D:36:7B:2D:5A:6A:66:35:41:2B:0F:22:83:5F:69:E4:7B:3F:65:62:45:46:A7:04"
+		fp2     = "df6ff72fe9116521268f6f2dd4966f51df479883fe7037b39f75916ac3049d1a"
+		spki    = "FsDbM0rUYIiL3V339eIKqiz6HPSB+Pz2WeAWhqlqh8U="
+	)
+
+	canonicalize := func(t *testing.T, matcher string) string {
+		t.Helper()
+		value, err := parser.ParseValue(strings.NewReader(matcher))
+		require.NoError(t, err, matcher)
+		bs, err := CanonicalizeMatcher(value)
+		require.NoError(t, err, matcher)
+		return string(bs)
+	}
+
+	t.Run("equal", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			label string
+			a, b  string
+		}{
+			{"key order", `{"self_signed": false, "fingerprint": "` + fp1 + `"}`,
+				`{"fingerprint": "` + fp1 + `", "self_signed": false}`},
+			{"key case", `{"san_dns": {"is": "a.example.com"}}`, `{"SAN_DNS": {"is": "a.example.com"}}`},
+			{"single value", `{"fingerprint": "` + fp1 + `"}`, `{"fingerprint": ["` + fp1 + `"]}`},
+			{"list order", `{"fingerprint": ["` + fp1 + `", "` + fp2 + `"]}`,
+				`{"fingerprint": ["` + fp2 + `", "` + fp1 + `"]}`},
+			{"duplicates", `{"fingerprint": ["` + fp1 + `", "` + fp1 + `"]}`, `{"fingerprint": "` + fp1 + `"}`},
+			{"fingerprint format", `{"fingerprint": "` + fp1Long + `"}`, `{"fingerprint": "sha256:` + fp1 + `"}`},
+			{"fingerprint comment", `{"fingerprint": "` + fp1 + ` # alice's laptop"}`, `{"fingerprint": "` + fp1 + `"}`},
+			{"issuer fingerprint format", `{"issuer_fingerprint": ["` + fp1Long + `"]}`, `{"issuer_fingerprint": "` + fp1 + `"}`},
+			{"SPKI hash", `{"spki_hash": "FsDbM0rUYIiL3V339eIKqiz6HPSB+Pz2WeAWhqlqh8V="}`, `{"spki_hash": ["` + spki + `"]}`},
+			{"SAN whitespace", `{"san_email": {"is": " alice@example.com "}}`, `{"san_email": {"is": "alice@example.com"}}`},
+			{"single branch", `[{"fingerprint": "` + fp1 + `"}]`, `{"fingerprint": "` + fp1 + `"}`},
+			{"negated", `{"NOT": [{"fingerprint": "` + fp1Long + `"}]}`, `{"not": {"fingerprint": "` + fp1 + `"}}`},
+			{"warn", `{"self_signed": false, "warn": {"ISSUER_FINGERPRINT": "` + fp1Long + `"}}`,
+				`{"self_signed": false, "warn": {"issuer_fingerprint": "` + fp1 + `"}}`},
+		} {
+			assert.Equal(t, canonicalize(t, tc.a), canonicalize(t, tc.b), tc.label)
+		}
+	})
+
+	t.Run("different", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			label string
+			a, b  string
+		}{
+			{"fingerprints", `{"fingerprint": "` + fp1 + `"}`, `{"fingerprint": "` + fp2 + `"}`},
+			{"form", `{"fingerprint": "` + fp1 + `"}`, `{"fingerprint": {"prefix": "1785"}}`},
+			{"branch order", `[{"self_signed": true}, {"fingerprint": "` + fp1 + `"}]`,
+				`[{"fingerprint": "` + fp1 + `"}, {"self_signed": true}]`},
+			{"ordered list", `{"subject": {"ou": ["eng", "backend"]}}`, `{"subject": {"ou": ["backend", "eng"]}}`},
+			{"SAN case", `{"san_email": {"is": "alice@example.com"}}`, `{"san_email": {"is": "Alice@example.com"}}`},
+			{"negated", `{"fingerprint": "` + fp1 + `"}`, `{"not": {"fingerprint": "` + fp1 + `"}}`},
+		} {
+			assert.NotEqual(t, canonicalize(t, tc.a), canonicalize(t, tc.b), tc.label)
+		}
+	})
+
+	t.Run("output", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t,
+			`[{"fingerprint":["${LAPTOP}","`+fp1+`","sha1:b1e6a2dcdd6b87a49bc57c3b7c7f1c749adb8836"],`+
+				`"san_dns":{"ends_with":".example.com","optional":true}},{"spki_hash":["`+spki+`"]}]`,
+			canonicalize(t, `[
+				{"SAN_DNS": {"optional": true, "ends_with": ".example.com"},
+				 "fingerprint": ["${LAPTOP}", "`+fp1Long+`", "sha1:B1:E6:A2:DC:DD:6B:87:A4:9B:C5:7C:3B:7C:7F:1C:74:9A:DB:88:36"]},
+				{"spki_hash": "`+spki+`"}
+			]`))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, matcher := range []string{
+			`{"fingerprint": "not a fingerprint"}`,
+			`{"unknown": true}`,
+			`"` + fp1 + `"`,
+		} {
+			value, err := parser.ParseValue(strings.NewReader(matcher))
+			require.NoError(t, err)
+			_, err = CanonicalizeMatcher(value)
+			assert.Error(t, err, matcher)
+		}
+	})
+}
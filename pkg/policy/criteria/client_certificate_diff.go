@@ -0,0 +1,239 @@
+package criteria
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// A CertificateMatcherChangeType is the type of a CertificateMatcherChange.
+type CertificateMatcherChangeType string
+
+// Certificate matcher change types.
+const (
+	CertificateMatcherAdded   CertificateMatcherChangeType = "added"
+	CertificateMatcherRemoved CertificateMatcherChangeType = "removed"
+	CertificateMatcherChanged CertificateMatcherChangeType = "changed"
+)
+
+// A CertificateMatcherChange is a difference between two certificate
+// matchers, as returned by DiffCertificateMatchers.
+type CertificateMatcherChange struct {
+	Type CertificateMatcherChangeType
+	// Path are the keys of the changed value within the matcher, like
+	// ["0", "san_dns", "ends_with"] for the ends_with operator of the SAN DNS
+	// condition of the first branch. It's empty if the whole matcher changed.
+	Path []string
+	// Old is the removed or previous value, and New the added or current
+	// value. A value added to or removed from a list, like a fingerprint, is a
+	// change of its own, with the path of the list.
+	Old, New parser.Value
+}
+
+// String returns a description of the change for a reviewer, like:
+//
+//	added fingerprint: "17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704"
+func (c CertificateMatcherChange) String() string {
+	path := strings.Join(c.Path, ".")
+	if path == "" {
+		path = "matcher"
+	}
+	switch c.Type {
+	case CertificateMatcherAdded:
+		return fmt.Sprintf("added %s: %s", path, c.New)
+	case CertificateMatcherRemoved:
+		return fmt.Sprintf("removed %s: %s", path, c.Old)
+	default:
+		return fmt.Sprintf("changed %s: %s to %s", path, c.Old, c.New)
+	}
+}
+
+// DiffCertificateMatchers returns the changes from the certificate matcher a
+// to b, e.g. so that a change to a route's client_certificate criterion can be
+// reviewed condition by condition. Both matchers must be valid.
+//
+// Branches are compared by position, and conditions by key, whatever their
+// case. Lists are compared as sets, so that reordering one isn't a change,
+// except for the subject ou condition and the serial_number mod operator,
+// whose order is significant. Values are otherwise compared as written: the
+// same fingerprint in a different format is a change.
+func DiffCertificateMatchers(a, b parser.Value) ([]CertificateMatcherChange, error) {
+	if err := ValidateCertificateMatcher(a); err != nil {
+		return nil, fmt.Errorf("invalid old certificate matcher: %w", err)
+	}
+	if err := ValidateCertificateMatcher(b); err != nil {
+		return nil, fmt.Errorf("invalid new certificate matcher: %w", err)
+	}
+
+	var d certMatcherDiff
+	d.matchers(nil, a, b)
+	return d.changes, nil
+}
+
+type certMatcherDiff struct {
+	changes []CertificateMatcherChange
+}
+
+func (d *certMatcherDiff) add(
+	typ CertificateMatcherChangeType, path []string, oldValue, newValue parser.Value,
+) {
+	d.changes = append(d.changes, CertificateMatcherChange{
+		Type: typ,
+		Path: slices.Clone(path),
+		Old:  oldValue,
+		New:  newValue,
+	})
+}
+
+// matchers adds the changes between two matchers. A matcher which is negated
+// in only one of them is changed as a whole.
+func (d *certMatcherDiff) matchers(path []string, a, b parser.Value) {
+	ao, aIsObject := a.(parser.Object)
+	bo, bIsObject := b.(parser.Object)
+
+	var aNot, bNot string
+	if aIsObject {
+		aNot = certMatcherNotKey(ao)
+	}
+	if bIsObject {
+		bNot = certMatcherNotKey(bo)
+	}
+	switch {
+	case aNot != "" && bNot != "":
+		d.matchers(append(path, "not"), ao[aNot], bo[bNot])
+		return
+	case aNot != "" || bNot != "":
+		if !certMatcherValuesEqual(a, b) {
+			d.add(CertificateMatcherChanged, path, a, b)
+		}
+		return
+	case aIsObject && bIsObject:
+		d.branches(path, ao, bo)
+		return
+	}
+
+	as, bs := certMatcherDiffBranches(a), certMatcherDiffBranches(b)
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		p := append(slices.Clone(path), strconv.Itoa(i))
+		switch {
+		case i >= len(as):
+			d.add(CertificateMatcherAdded, p, nil, bs[i])
+		case i >= len(bs):
+			d.add(CertificateMatcherRemoved, p, as[i], nil)
+		default:
+			d.branches(p, as[i], bs[i])
+		}
+	}
+}
+
+// certMatcherDiffBranches returns the branches of a valid, non-negated
+// matcher.
+func certMatcherDiffBranches(data parser.Value) []parser.Object {
+	switch v := data.(type) {
+	case parser.Object:
+		return []parser.Object{v}
+	case parser.Array:
+		branches := make([]parser.Object, len(v))
+		for i := range v {
+			branches[i] = v[i].(parser.Object)
+		}
+		return branches
+	}
+	return nil
+}
+
+// branches adds the changes between the conditions of two branches.
+func (d *certMatcherDiff) branches(path []string, a, b parser.Object) {
+	lower := func(obj parser.Object) parser.Object {
+		lowered := make(parser.Object, len(obj))
+		for k, v := range obj {
+			lowered[strings.ToLower(k)] = v
+		}
+		return lowered
+	}
+	d.objects(path, lower(a), lower(b))
+}
+
+// objects adds the changes between two objects, in the order of their keys.
+func (d *certMatcherDiff) objects(path []string, a, b parser.Object) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	for _, k := range keys {
+		p := append(slices.Clone(path), k)
+		av, aok := a[k]
+		bv, bok := b[k]
+		switch {
+		case !aok:
+			d.add(CertificateMatcherAdded, p, nil, bv)
+		case !bok:
+			d.add(CertificateMatcherRemoved, p, av, nil)
+		default:
+			d.values(p, av, bv)
+		}
+	}
+}
+
+// values adds the changes between two values of a condition.
+func (d *certMatcherDiff) values(path []string, a, b parser.Value) {
+	if certMatcherValuesEqual(a, b) {
+		return
+	}
+
+	ao, aIsObject := a.(parser.Object)
+	bo, bIsObject := b.(parser.Object)
+	if aIsObject && bIsObject {
+		d.objects(path, ao, bo)
+		return
+	}
+
+	aa, aIsArray := a.(parser.Array)
+	ba, bIsArray := b.(parser.Array)
+	if (aIsArray || bIsArray) && !aIsObject && !bIsObject && !certMatcherListOrdered(path) {
+		// a single value is the same as a list of one
+		if !aIsArray {
+			aa = parser.Array{a}
+		}
+		if !bIsArray {
+			ba = parser.Array{b}
+		}
+
+		for _, v := range aa {
+			if !slices.ContainsFunc(ba, func(e parser.Value) bool { return certMatcherValuesEqual(v, e) }) {
+				d.add(CertificateMatcherRemoved, path, v, nil)
+			}
+		}
+		for _, v := range ba {
+			if !slices.ContainsFunc(aa, func(e parser.Value) bool { return certMatcherValuesEqual(v, e) }) {
+				d.add(CertificateMatcherAdded, path, nil, v)
+			}
+		}
+		return
+	}
+
+	d.add(CertificateMatcherChanged, path, a, b)
+}
+
+// certMatcherListOrdered returns true if the order of the list at the path
+// within a matcher is significant.
+func certMatcherListOrdered(path []string) bool {
+	n := len(path)
+	return n >= 2 && ((path[n-2] == "subject" && path[n-1] == "ou") ||
+		(path[n-2] == "serial_number" && path[n-1] == "mod"))
+}
+
+func certMatcherValuesEqual(a, b parser.Value) bool {
+	return ast.Compare(a.RegoValue(), b.RegoValue()) == 0
+}
@@ -0,0 +1,107 @@
+package criteria
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+func TestDiffCertificateMatchers(t *testing.T) {
+	t.Parallel()
+
+	const (
+		fp1 = "17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704"
+		fp2 = "df6ff72fe9116521268f6f2dd4966f51df479883fe7037b39f75916ac3049d1a"
+	)
+
+	for _, tc := range []struct {
+		label    string
+		a, b     string
+		expected []string
+	}{
+		{"unchanged", `{"fingerprint": ["` + fp1 + `", "` + fp2 + `"]}`, `{"fingerprint": ["` + fp2 + `", "` + fp1 + `"]}`,
+			nil},
+		{"single value", `{"fingerprint": "` + fp1 + `"}`, `{"fingerprint": ["` + fp1 + `"]}`, nil},
+		{"condition key case", `{"san_dns": {"is": "a.example.com"}}`, `{"SAN_DNS": {"is": "a.example.com"}}`, nil},
+		{"list values", `{"fingerprint": "` + fp1 + `"}`, `{"fingerprint": ["` + fp2 + `"]}`, []string{
+			`removed fingerprint: "` + fp1 + `"`,
+			`added fingerprint: "` + fp2 + `"`,
+		}},
+		{"conditions", `{"fingerprint": "` + fp1 + `", "self_signed": false}`, `{"san_dns": {"is": "a.example.com"}, "self_signed": true}`, []string{
+			`removed fingerprint: "` + fp1 + `"`,
+			`added san_dns: {"is":"a.example.com"}`,
+			`changed self_signed: false to true`,
+		}},
+		{"operators", `{"san_dns": {"ends_with": ".example.com", "is_not": "a.example.com"}}`, `{"san_dns": {"ends_with": [".example.com", ".example.org"], "is_not": "b.example.com"}}`, []string{
+			`added san_dns.ends_with: ".example.org"`,
+			`changed san_dns.is_not: "a.example.com" to "b.example.com"`,
+		}},
+		{"ordered list", `{"subject": {"ou": ["eng", "backend"]}}`, `{"subject": {"ou": ["backend", "eng"]}}`, []string{
+			`changed subject.ou: ["eng","backend"] to ["backend","eng"]`,
+		}},
+		{"ordered pair", `{"serial_number": {"mod": [2, 0]}}`, `{"serial_number": {"mod": [4, 0]}}`, []string{
+			`changed serial_number.mod: [2,0] to [4,0]`,
+		}},
+		{"form", `{"fingerprint": "` + fp1 + `"}`, `{"fingerprint": {"prefix": "1785"}}`, []string{
+			`changed fingerprint: "` + fp1 + `" to {"prefix":"1785"}`,
+		}},
+		{"branches", `{"fingerprint": "` + fp1 + `"}`, `[{"fingerprint": "` + fp1 + `"}, {"san_email": {"is": "a@example.com"}}]`, []string{
+			`added 1: {"san_email":{"is":"a@example.com"}}`,
+		}},
+		{"removed branch", `[{"self_signed": true}, {"self_signed": false}]`, `[{"self_signed": false}]`, []string{
+			`changed 0.self_signed: true to false`,
+			`removed 1: {"self_signed":false}`,
+		}},
+		{"negated", `{"not": {"self_signed": true}}`, `{"NOT": [{"self_signed": false}]}`, []string{
+			`changed not.0.self_signed: true to false`,
+		}},
+		{"negation", `{"self_signed": true}`, `{"not": {"self_signed": true}}`, []string{
+			`changed matcher: {"self_signed":true} to {"not":{"self_signed":true}}`,
+		}},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := parser.ParseValue(strings.NewReader(tc.a))
+			require.NoError(t, err)
+			b, err := parser.ParseValue(strings.NewReader(tc.b))
+			require.NoError(t, err)
+
+			changes, err := DiffCertificateMatchers(a, b)
+			require.NoError(t, err)
+			var actual []string
+			for _, c := range changes {
+				actual = append(actual, c.String())
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	t.Run("structured", func(t *testing.T) {
+		t.Parallel()
+
+		changes, err := DiffCertificateMatchers(
+			parser.Object{"san_dns": parser.Object{"ends_with": parser.Array{parser.String(".example.com")}}},
+			parser.Object{"san_dns": parser.Object{"ends_with": parser.Array{parser.String(".example.org")}}})
+		require.NoError(t, err)
+		assert.Equal(t, []CertificateMatcherChange{
+			{Type: CertificateMatcherRemoved, Path: []string{"san_dns", "ends_with"}, Old: parser.String(".example.com")},
+			{Type: CertificateMatcherAdded, Path: []string{"san_dns", "ends_with"}, New: parser.String(".example.org")},
+		}, changes)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		valid := parser.Object{"self_signed": parser.Boolean(true)}
+		invalid := parser.Object{"bogus": parser.Boolean(true)}
+		_, err := DiffCertificateMatchers(invalid, valid)
+		assert.EqualError(t, err, "invalid old certificate matcher: unsupported certificate matcher condition: bogus")
+		_, err = DiffCertificateMatchers(valid, invalid)
+		assert.EqualError(t, err, "invalid new certificate matcher: unsupported certificate matcher condition: bogus")
+	})
+}
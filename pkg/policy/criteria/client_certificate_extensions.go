@@ -0,0 +1,248 @@
+package criteria
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// certKeyUsages are the names of the key usage bits, as in RFC 5280.
+var certKeyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"keyCertSign":       x509.KeyUsageCertSign,
+	"cRLSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+// addCertKeyUsageCondition adds a condition on the certificate's key usage
+// bits. The all_of operator requires every one of the given bits to be set,
+// and the any_of operator requires at least one of them to be set. A
+// certificate without the key usage extension has no bits set.
+func addCertKeyUsageCondition(body *ast.Body, data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for certificate key usage matcher, got: %T", data)
+	}
+
+	for k, v := range obj {
+		switch k {
+		case "all_of":
+			mask, err := parseCertKeyUsages(k, v)
+			if err != nil {
+				return err
+			}
+			*body = append(*body, ast.Equal.Expr(
+				ast.CallTerm(ast.RefTerm(ast.VarTerm("bits"), ast.StringTerm("and")),
+					ast.MustParseTerm("cert.KeyUsage"), ast.IntNumberTerm(int(mask))),
+				ast.IntNumberTerm(int(mask))))
+		case "any_of":
+			mask, err := parseCertKeyUsages(k, v)
+			if err != nil {
+				return err
+			}
+			*body = append(*body, ast.NotEqual.Expr(
+				ast.CallTerm(ast.RefTerm(ast.VarTerm("bits"), ast.StringTerm("and")),
+					ast.MustParseTerm("cert.KeyUsage"), ast.IntNumberTerm(int(mask))),
+				ast.IntNumberTerm(0)))
+		default:
+			return fmt.Errorf("unsupported certificate key usage condition: %s", k)
+		}
+	}
+	return nil
+}
+
+func validateCertKeyUsageMatcher(data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for certificate key usage matcher, got: %T", data)
+	}
+
+	for k, v := range obj {
+		var err error
+		switch k {
+		case "all_of", "any_of":
+			_, err = parseCertKeyUsages(k, v)
+		default:
+			err = fmt.Errorf("unsupported certificate key usage condition: %s", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCertKeyUsages returns the key usage bits named by the operand of the
+// key usage operator op.
+func parseCertKeyUsages(op string, data parser.Value) (x509.KeyUsage, error) {
+	pa, ok := data.(parser.Array)
+	if !ok {
+		return 0, fmt.Errorf("certificate key usage %s expects an array of strings (was %v)", op, data)
+	} else if len(pa) == 0 {
+		return 0, fmt.Errorf("certificate key usage %s must not be empty", op)
+	}
+
+	var mask x509.KeyUsage
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return 0, fmt.Errorf("certificate key usage must be a string (was %v)", v)
+		}
+		u, ok := certKeyUsages[string(s)]
+		if !ok {
+			return 0, fmt.Errorf("unsupported certificate key usage: %s", string(s))
+		}
+		mask |= u
+	}
+	return mask, nil
+}
+
+// certExtKeyUsages are the names of the supported extended key usages.
+var certExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// addCertExtKeyUsageCondition adds a condition on the certificate's extended
+// key usages. The exactly operator requires the set of usages to be equal to
+// the given set, so a certificate with any other usage, including one not
+// known by name, doesn't match.
+func addCertExtKeyUsageCondition(body *ast.Body, data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for certificate extended key usage matcher, got: %T", data)
+	}
+
+	for k, v := range obj {
+		switch k {
+		case "exactly":
+			usages, err := parseCertExtKeyUsages(v)
+			if err != nil {
+				return err
+			}
+
+			set := ast.NewSet()
+			for _, u := range usages {
+				set.Add(ast.IntNumberTerm(int(u)))
+			}
+			*body = append(*body,
+				ast.Equal.Expr(ast.MustParseTerm(`{u | u := cert.ExtKeyUsage[_]}`), ast.NewTerm(set)),
+				ast.Equal.Expr(ast.MustParseTerm(`[u | u := cert.UnknownExtKeyUsage[_]]`), ast.ArrayTerm()))
+		default:
+			return fmt.Errorf("unsupported certificate extended key usage condition: %s", k)
+		}
+	}
+	return nil
+}
+
+func validateCertExtKeyUsageMatcher(data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for certificate extended key usage matcher, got: %T", data)
+	}
+
+	for k, v := range obj {
+		var err error
+		switch k {
+		case "exactly":
+			_, err = parseCertExtKeyUsages(v)
+		default:
+			err = fmt.Errorf("unsupported certificate extended key usage condition: %s", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseCertExtKeyUsages(data parser.Value) ([]x509.ExtKeyUsage, error) {
+	pa, ok := data.(parser.Array)
+	if !ok {
+		return nil, fmt.Errorf("certificate extended key usage exactly expects an array of strings (was %v)", data)
+	} else if len(pa) == 0 {
+		return nil, errors.New("certificate extended key usage exactly must not be empty")
+	}
+
+	usages := make([]x509.ExtKeyUsage, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("certificate extended key usage must be a string (was %v)", v)
+		}
+		u, ok := certExtKeyUsages[string(s)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported certificate extended key usage: %s", string(s))
+		}
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// addCertRequireCRLDPCondition adds a condition requiring that the certificate
+// has at least one CRL distribution point, so that its revocation can be
+// checked. If false there is no requirement.
+func addCertRequireCRLDPCondition(body *ast.Body, data parser.Value) error {
+	b, err := parseCertRequireCRLDP(data)
+	if err != nil {
+		return err
+	}
+
+	if b {
+		// the list may be null, so it's counted via a comprehension
+		*body = append(*body, ast.MustParseExpr(`count([x | x := cert.CRLDistributionPoints[_]]) > 0`))
+	}
+	return nil
+}
+
+func parseCertRequireCRLDP(data parser.Value) (bool, error) {
+	b, ok := data.(parser.Boolean)
+	if !ok {
+		return false, fmt.Errorf("certificate require_crl_dp condition expects a boolean (was %v)", data)
+	}
+	return bool(b), nil
+}
+
+// The embedded signed certificate timestamp list extension (RFC 6962) has the
+// OID 1.3.6.1.4.1.11129.2.4.2, which the parsed certificate represents as an
+// array of integers.
+var certSCTExtensionExpr = ast.MustParseExpr(
+	`count([x | x := cert.Extensions[_]; x.Id == [1, 3, 6, 1, 4, 1, 11129, 2, 4, 2]]) > 0`)
+
+// addCertRequireSCTCondition adds a condition requiring that the certificate
+// has embedded signed certificate timestamps, as certificates logged by a
+// public CA do. The timestamps themselves are not verified. If false there is
+// no requirement.
+func addCertRequireSCTCondition(body *ast.Body, data parser.Value) error {
+	b, err := parseCertRequireSCT(data)
+	if err != nil {
+		return err
+	}
+
+	if b {
+		*body = append(*body, certSCTExtensionExpr)
+	}
+	return nil
+}
+
+func parseCertRequireSCT(data parser.Value) (bool, error) {
+	b, ok := data.(parser.Boolean)
+	if !ok {
+		return false, fmt.Errorf("certificate require_sct condition expects a boolean (was %v)", data)
+	}
+	return bool(b), nil
+}
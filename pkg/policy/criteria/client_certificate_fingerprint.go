@@ -0,0 +1,545 @@
+package criteria
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// validateCertFingerprintMatcher returns the error addCertFingerprintCondition
+// would return for the matcher. Pins are supplied to the generator, and the
+// file is read by it, so neither is resolved.
+func validateCertFingerprintMatcher(data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		_, err := parseCertFingerprints(data, nil)
+		return err
+	}
+
+	var err error
+	switch certFingerprintObjectForm(obj) {
+	case "from_data":
+		_, err = parseCertFingerprintFromData(obj)
+	case "file":
+		_, err = parseCertFingerprintFile(obj)
+	default:
+		_, err = parseCertFingerprintPrefix(obj)
+	}
+	return err
+}
+
+func addCertFingerprintCondition(
+	b *certMatcherBranch, data parser.Value, lookupPin func(name string) (string, bool),
+) error {
+	var fingerprints []string
+	if obj, ok := data.(parser.Object); ok {
+		switch certFingerprintObjectForm(obj) {
+		case "from_data":
+			ref, err := parseCertFingerprintFromData(obj)
+			if err != nil {
+				return err
+			}
+			b.body = append(b.body, ast.Member.Expr(ast.VarTerm("fingerprint"), ast.NewTerm(ref)))
+			return nil
+		case "file":
+			path, err := parseCertFingerprintFile(obj)
+			if err != nil {
+				return err
+			}
+			fingerprints, err = readCertFingerprintFile(path)
+			if err != nil {
+				return parser.ErrorAt(err, "file")
+			}
+		default:
+			prefix, err := parseCertFingerprintPrefix(obj)
+			if err != nil {
+				return err
+			}
+			b.body = append(b.body, ast.StartsWith.Expr(ast.VarTerm("fingerprint"), ast.StringTerm(prefix)))
+			return nil
+		}
+	} else {
+		var err error
+		fingerprints, err = parseCertFingerprints(data, lookupPin)
+		if err != nil {
+			return err
+		}
+	}
+
+	hasSHA1 := false
+	for _, f := range fingerprints {
+		if strings.HasPrefix(f, sha1CertFingerprintPrefix) {
+			hasSHA1 = true
+		}
+	}
+
+	if !hasSHA1 {
+		addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("fingerprint"), "allowed_fingerprints", fingerprints)
+		return nil
+	}
+
+	// SHA-1 fingerprints are only computed when one has been configured
+	b.body = append(b.body,
+		ast.MustParseExpr(`cert_fingerprints := [
+			fingerprint,
+			concat("", ["sha1:", crypto.sha1(base64.decode(cert.Raw))])
+		]`))
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("cert_fingerprints[_]"), "allowed_fingerprints", fingerprints)
+	return nil
+}
+
+// The prefix of a certificate fingerprint is hex-encoded whole bytes.
+var certFingerprintPrefixRE = regexp.MustCompile("^(?:[0-9a-f]{2}){1,32}$")
+
+// parseCertFingerprintPrefix returns the prefix of a fingerprint prefix
+// condition, like {prefix: ab12}, which matches certificates whose SHA-256
+// fingerprint starts with the prefix, e.g. a batch of certificates issued
+// together. The prefix is case-insensitive.
+func parseCertFingerprintPrefix(obj parser.Object) (string, error) {
+	for k := range obj {
+		if k != "prefix" {
+			return "", fmt.Errorf("unsupported certificate fingerprint condition: %s", k)
+		}
+	}
+
+	s, ok := obj["prefix"].(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate fingerprint prefix expects a string (was %v)", obj["prefix"])
+	}
+	prefix := strings.ToLower(string(s))
+	if !certFingerprintPrefixRE.MatchString(prefix) {
+		return "", fmt.Errorf("certificate fingerprint prefix must be an even number of hex digits (was %s)", string(s))
+	}
+	return prefix, nil
+}
+
+// A segment of a data document path is a Rego identifier.
+var certDataPathSegmentRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// certFingerprintObjectForm returns the form of a fingerprint condition
+// object: from_data, file or prefix.
+func certFingerprintObjectForm(obj parser.Object) string {
+	for _, form := range []string{"from_data", "file"} {
+		if _, ok := obj[form]; ok {
+			return form
+		}
+	}
+	return "prefix"
+}
+
+// parseCertFingerprintFile returns the path of a fingerprint file condition,
+// like {file: /etc/pomerium/pins.txt}.
+func parseCertFingerprintFile(obj parser.Object) (string, error) {
+	for k := range obj {
+		if k != "file" {
+			return "", fmt.Errorf("unsupported certificate fingerprint condition: %s", k)
+		}
+	}
+
+	s, ok := obj["file"].(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate fingerprint file expects a string (was %v)", obj["file"])
+	} else if s == "" {
+		return "", errors.New("certificate fingerprint file must not be empty")
+	}
+	return string(s), nil
+}
+
+// readCertFingerprintFile reads the fingerprints of a fingerprint file, which
+// has one fingerprint per line, in any of the accepted formats. Blank lines
+// and comments, from a # at the start of a line or preceded by whitespace,
+// are ignored.
+func readCertFingerprintFile(path string) ([]string, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate fingerprint file: %w", err)
+	}
+
+	var fingerprints []string
+	for i, line := range strings.Split(string(bs), "\n") {
+		line = strings.TrimSpace(certValueCommentRE.ReplaceAllString(line, ""))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		f, err := canonicalCertFingerprint(parser.String(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		fingerprints = append(fingerprints, string(f.(ast.String)))
+	}
+	if len(fingerprints) == 0 {
+		return nil, fmt.Errorf("certificate fingerprint file contains no fingerprints: %s", path)
+	}
+	return fingerprints, nil
+}
+
+// parseCertFingerprintFromData returns the reference to the data document of
+// a fingerprint from_data condition, like {from_data: certs.allowed}, which
+// matches certificates whose fingerprint is in data.certs.allowed. The
+// document is an array or set of fingerprints, or an object whose values are
+// fingerprints, loaded into OPA separately, e.g. from a bundle. Its
+// fingerprints must be canonical: lowercase hex SHA-256 fingerprints.
+//
+// The path is relative to data, and may not refer to the policy itself.
+func parseCertFingerprintFromData(obj parser.Object) (ast.Ref, error) {
+	for k := range obj {
+		if k != "from_data" {
+			return nil, fmt.Errorf("unsupported certificate fingerprint condition: %s", k)
+		}
+	}
+
+	s, ok := obj["from_data"].(parser.String)
+	if !ok {
+		return nil, fmt.Errorf("certificate fingerprint from_data expects a string (was %v)", obj["from_data"])
+	}
+
+	segments := strings.Split(string(s), ".")
+	ref := ast.Ref{ast.DefaultRootDocument}
+	for _, segment := range segments {
+		if !certDataPathSegmentRE.MatchString(segment) {
+			return nil, fmt.Errorf("invalid certificate fingerprint from_data path: %q", string(s))
+		}
+		ref = append(ref, ast.StringTerm(segment))
+	}
+	switch segments[0] {
+	case "data", "pomerium":
+		return nil, fmt.Errorf("certificate fingerprint from_data path must be relative to data, "+
+			"and not within the policy (was %s)", string(s))
+	}
+	return ref, nil
+}
+
+// addCertAllowedValuesCondition adds a condition requiring that value is one
+// of the allowed values. A single allowed value, the common case, is compared
+// directly rather than assigned to an array and checked for membership.
+//
+// Otherwise the allowed values are a rule of their own, named by the hash of
+// its sorted values, which the body checks for membership. Structurally
+// identical sets are the same rule, and so are emitted once in the generated
+// policy however many matchers use them.
+func addCertAllowedValuesCondition(
+	b *certMatcherBranch, body *ast.Body, value *ast.Term, name string, allowed []string,
+) {
+	allowed = slices.Clone(allowed)
+	slices.Sort(allowed)
+	allowed = slices.Compact(allowed)
+	if len(allowed) == 1 {
+		*body = append(*body, ast.Equal.Expr(value, ast.StringTerm(allowed[0])))
+		return
+	}
+
+	ra := ast.NewArray()
+	h := sha256.New()
+	for _, v := range allowed {
+		ra = ra.Append(ast.StringTerm(v))
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+
+	setName := fmt.Sprintf("cert_%s_%x", name, h.Sum(nil)[:8])
+	b.allowedSets = append(b.allowedSets, &ast.Rule{
+		Head: &ast.Head{
+			Name:      ast.Var(setName),
+			Reference: ast.Ref{ast.VarTerm(setName)},
+			Value:     ast.NewTerm(ra),
+			Assign:    true,
+		},
+		Body: ast.NewBody(ast.NewExpr(ast.BooleanTerm(true))),
+	})
+	*body = append(*body, ast.Equal.Expr(value, ast.VarTerm(setName+"[_]")))
+}
+
+// certListErrorAt returns err as caused by the ith element of a condition which
+// is a string or array of strings. A single string is its own element.
+func certListErrorAt(data parser.Value, err error, i int) error {
+	if _, ok := data.(parser.Array); !ok {
+		return err
+	}
+	return parser.ErrorAt(err, strconv.Itoa(i))
+}
+
+// A fingerprint of the form ${NAME} references a pin supplied to the Generator.
+var certFingerprintPinRE = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// parseCertFingerprints returns the canonical fingerprints of a fingerprint
+// condition, resolving any pin references with lookupPin. If lookupPin is nil,
+// pin references are skipped.
+func parseCertFingerprints(
+	data parser.Value, lookupPin func(name string) (string, bool),
+) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, errors.New("certificate fingerprint condition expects a string or array of strings")
+	}
+
+	fingerprints := make([]string, 0, len(pa))
+	for i, v := range pa {
+		if s, ok := v.(parser.String); ok {
+			if m := certFingerprintPinRE.FindStringSubmatch(string(s)); m != nil {
+				if lookupPin == nil {
+					continue
+				}
+				pin, ok := lookupPin(m[1])
+				if !ok {
+					return nil, certListErrorAt(data,
+						fmt.Errorf("certificate fingerprint pin is not set: %s", m[1]), i)
+				}
+				v = parser.String(pin)
+			}
+		}
+
+		f, err := canonicalCertFingerprint(v)
+		if err != nil {
+			return nil, certListErrorAt(data, err, i)
+		}
+		fingerprints = append(fingerprints, string(f.(ast.String)))
+	}
+	return fingerprints, nil
+}
+
+// The long certificate fingerprint format is 32 hex-encoded bytes separated by
+// colons. The hex is either all uppercase, like openssl, or all lowercase, like
+// Git and some other tools.
+var longCertFingerprintRE = regexp.MustCompile(
+	"^(?:[0-9A-F]{2}(:[0-9A-F]{2}){31}|[0-9a-f]{2}(:[0-9a-f]{2}){31})$")
+
+// The short certificate fingerprint format is 32 lowercase hex-encoded bytes.
+var shortCertFingerprintRE = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// The SHA-1 variants of the long and short formats are 20 bytes.
+var (
+	longSHA1CertFingerprintRE = regexp.MustCompile(
+		"^(?:[0-9A-F]{2}(:[0-9A-F]{2}){19}|[0-9a-f]{2}(:[0-9a-f]{2}){19})$")
+	shortSHA1CertFingerprintRE = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// Canonical SHA-1 fingerprints keep their algorithm prefix so that they can be
+// distinguished from the (default) SHA-256 fingerprints.
+const sha1CertFingerprintPrefix = "sha1:"
+
+// A FingerprintFormat describes a certificate fingerprint format accepted by
+// the client_certificate criterion.
+type FingerprintFormat struct {
+	// Name is a short description of the format.
+	Name string
+	// Algorithm is the hash algorithm of the fingerprint. A fingerprint may
+	// be prefixed with its algorithm, like "sha1:...", and must be unless the
+	// algorithm is SHA-256.
+	Algorithm string
+	// Pattern is a regular expression matching the fingerprint, without its
+	// algorithm prefix.
+	Pattern string
+	// Example is a fingerprint in the format.
+	Example string
+}
+
+// certFingerprintFormats are the accepted fingerprint formats, along with the
+// prefix of their canonical form.
+var certFingerprintFormats = []struct {
+	FingerprintFormat
+	re     *regexp.Regexp
+	prefix string
+}{
+	{
+		FingerprintFormat{
+			Name:      "SHA-256 hex",
+			Algorithm: "sha256",
+			Example:   "17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704",
+		},
+		shortCertFingerprintRE, "",
+	},
+	{
+		FingerprintFormat{
+			Name:      "SHA-256 colon-separated hex",
+			Algorithm: "sha256",
+			Example:   "17:85:92:73:E8:A9:80:63:1D:36:7B:2D:5A:6A:66:35:41:2B:0F:22:83:5F:69:E4:7B:3F:65:62:45:46:A7:04",
+		},
+		longCertFingerprintRE, "",
+	},
+	{
+		FingerprintFormat{
+			Name:      "SHA-1 hex",
+			Algorithm: "sha1",
+			Example:   "sha1:b1e6a2dcdd6b87a49bc57c3b7c7f1c749adb8836",
+		},
+		shortSHA1CertFingerprintRE, sha1CertFingerprintPrefix,
+	},
+	{
+		FingerprintFormat{
+			Name:      "SHA-1 colon-separated hex",
+			Algorithm: "sha1",
+			Example:   "sha1:B1:E6:A2:DC:DD:6B:87:A4:9B:C5:7C:3B:7C:7F:1C:74:9A:DB:88:36",
+		},
+		longSHA1CertFingerprintRE, sha1CertFingerprintPrefix,
+	},
+}
+
+// FingerprintFormats returns the certificate fingerprint formats accepted by
+// the client_certificate criterion, e.g. to document them.
+func FingerprintFormats() []FingerprintFormat {
+	formats := make([]FingerprintFormat, len(certFingerprintFormats))
+	for i, format := range certFingerprintFormats {
+		formats[i] = format.FingerprintFormat
+		formats[i].Pattern = format.re.String()
+	}
+	return formats
+}
+
+// CanonicalizeFingerprint converts a certificate fingerprint, in any of the
+// formats accepted by the client_certificate criterion, into its canonical
+// form: lowercase hex without separators, prefixed with "sha1:" for SHA-1
+// fingerprints.
+func CanonicalizeFingerprint(fingerprint string) (string, error) {
+	v, err := canonicalCertFingerprint(parser.String(fingerprint))
+	if err != nil {
+		return "", err
+	}
+	return string(v.(ast.String)), nil
+}
+
+// canonicalCertFingeprint converts a single fingerprint value into the format
+// that our Rego logic generates.
+//
+// A fingerprint may be prefixed with its hash algorithm, e.g. "sha256:..." or
+// "sha1:...". Unprefixed fingerprints are assumed to be SHA-256. A "sha512:"
+// prefix is recognized, but rejected: the certificate's fingerprint is computed
+// by the generated Rego, which has no SHA-512 function.
+func canonicalCertFingerprint(data parser.Value) (ast.Value, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return nil, fmt.Errorf("certificate fingerprint must be a string (was %v)", data)
+	}
+
+	f := string(s)
+	if f == "" {
+		return nil, errors.New("certificate fingerprint must not be empty")
+	}
+
+	algorithm := "sha256"
+	if idx := strings.Index(f, ":"); idx > 2 {
+		algorithm, f = strings.ToLower(f[:idx]), f[idx+1:]
+	}
+
+	if algorithm == "sha512" {
+		return nil, fmt.Errorf("unsupported certificate fingerprint algorithm (%s): "+
+			"SHA-512 fingerprints can't be computed in Rego, use the SHA-256 fingerprint instead", algorithm)
+	}
+
+	for _, format := range certFingerprintFormats {
+		if format.Algorithm == algorithm && format.re.MatchString(f) {
+			f = strings.ToLower(strings.ReplaceAll(f, ":", ""))
+			return ast.String(format.prefix + f), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported certificate fingerprint format (%s)", string(s))
+}
+
+// The PEM fingerprint is the SHA-256 hash of the certificate's standard PEM
+// encoding (64 character lines and a trailing newline), as produced by running
+// sha256sum on a PEM file. It is not the same as the (DER) certificate
+// fingerprint reported by tools like `openssl x509 -fingerprint`.
+var certPEMFingerprintBody = ast.MustParseBody(`
+	pem_fingerprint := crypto.sha256(concat("", [
+		"-----BEGIN CERTIFICATE-----\n",
+		concat("\n", regex.find_n(".{1,64}", cert.Raw, -1)),
+		"\n-----END CERTIFICATE-----\n",
+	]))
+`)
+
+func addCertPEMFingerprintCondition(b *certMatcherBranch, data parser.Value) error {
+	fingerprints, err := parseCertSHA256Fingerprints(data, "PEM fingerprint")
+	if err != nil {
+		return err
+	}
+
+	b.body = append(b.body, certPEMFingerprintBody...)
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("pem_fingerprint"), "allowed_pem_fingerprints", fingerprints)
+	return nil
+}
+
+// parseCertSHA256Fingerprints returns the canonical fingerprints of a
+// condition which only supports SHA-256 fingerprints.
+func parseCertSHA256Fingerprints(data parser.Value, kind string) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, fmt.Errorf("certificate %s condition expects a string or array of strings", kind)
+	}
+
+	fingerprints := make([]string, 0, len(pa))
+	for i, v := range pa {
+		f, err := canonicalCertFingerprint(v)
+		if err != nil {
+			return nil, certListErrorAt(data, err, i)
+		}
+		if strings.HasPrefix(string(f.(ast.String)), sha1CertFingerprintPrefix) {
+			return nil, certListErrorAt(data,
+				fmt.Errorf("certificate %s must be a SHA-256 hash (was %s)", kind, v), i)
+		}
+		fingerprints = append(fingerprints, string(f.(ast.String)))
+	}
+	return fingerprints, nil
+}
+
+func addCertSPKIHashCondition(b *certMatcherBranch, data parser.Value) error {
+	hashes, err := parseCertSPKIHashes(data)
+	if err != nil {
+		return err
+	}
+
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("spki_hash"), "allowed_spki_hashes", hashes)
+	return nil
+}
+
+func parseCertSPKIHashes(data parser.Value) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, errors.New("certificate SPKI hash condition expects a string or array of strings")
+	}
+
+	hashes := make([]string, 0, len(pa))
+	for i, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, certListErrorAt(data,
+				fmt.Errorf("certificate SPKI hash must be a string (was %v)", v), i)
+		}
+
+		h := string(s)
+		if h == "" {
+			return nil, certListErrorAt(data, errors.New("certificate SPKI hash must not be empty"), i)
+		} else if b, err := base64.StdEncoding.DecodeString(h); err != nil || len(b) != 32 {
+			return nil, certListErrorAt(data,
+				fmt.Errorf("certificate SPKI hash must be a base64-encoded SHA-256 hash "+
+					"(was %s)", h), i)
+		}
+
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,378 @@
+package criteria
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The issuer is the certificate in the presented chain whose subject matches
+// the leaf certificate's issuer. The chain itself is unvalidated, so this
+// condition should be combined with validation of the client certificate.
+var certIssuerFingerprintBody = ast.MustParseBody(`
+	issuer := crypto.x509.parse_certificates(trim_space(input.http.client_certificate.intermediates))[_]
+	issuer.RawSubject == cert.RawIssuer
+	issuer_fingerprint := crypto.sha256(base64.decode(issuer.Raw))
+`)
+
+// validateCertIssuerFingerprintMatcher returns the error
+// addCertIssuerFingerprintCondition would return for the matcher. The trust
+// bundle is supplied to the generator, so it isn't resolved.
+func validateCertIssuerFingerprintMatcher(data parser.Value) error {
+	if obj, ok := data.(parser.Object); ok {
+		return parseCertIssuerFingerprintFromBundle(obj)
+	}
+	_, err := parseCertSHA256Fingerprints(data, "issuer fingerprint")
+	return err
+}
+
+// addCertIssuerFingerprintCondition adds a condition requiring that the
+// certificate was issued by an intermediate with one of the given SHA-256
+// fingerprints. Rather than listing the fingerprints, {from_bundle: true}
+// allows any of the CAs in the generator's trust bundle, so that the policy
+// stays in sync with the deployed trust store.
+func addCertIssuerFingerprintCondition(
+	b *certMatcherBranch, data parser.Value, bundleFingerprints func() ([]string, error),
+) error {
+	var fingerprints []string
+	var err error
+	if obj, ok := data.(parser.Object); ok {
+		err = parseCertIssuerFingerprintFromBundle(obj)
+		if err == nil {
+			fingerprints, err = bundleFingerprints()
+		}
+	} else {
+		fingerprints, err = parseCertSHA256Fingerprints(data, "issuer fingerprint")
+	}
+	if err != nil {
+		return err
+	}
+
+	b.body = append(b.body, certIssuerFingerprintBody...)
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("issuer_fingerprint"), "allowed_issuer_fingerprints", fingerprints)
+	return nil
+}
+
+// parseCertIssuerFingerprintFromBundle checks the from_bundle form of an
+// issuer_fingerprint condition, which is the only supported object form.
+func parseCertIssuerFingerprintFromBundle(obj parser.Object) error {
+	for k := range obj {
+		if k != "from_bundle" {
+			return fmt.Errorf("unsupported certificate issuer fingerprint operator: %s", k)
+		}
+	}
+
+	v, ok := obj["from_bundle"]
+	if !ok {
+		return errors.New("certificate issuer fingerprint condition expects a string, " +
+			"an array of strings, or from_bundle")
+	} else if b, ok := v.(parser.Boolean); !ok || !bool(b) {
+		return parser.ErrorAt(
+			fmt.Errorf("certificate issuer fingerprint from_bundle must be true (was %v)", v), "from_bundle")
+	}
+	return nil
+}
+
+// The root is the last certificate in the presented chain. Clients often omit
+// the root, since the server is expected to have it already, in which case the
+// last certificate is an intermediate and won't match a pinned root. Like the
+// issuer, the chain itself is unvalidated, so this condition should be
+// combined with validation of the client certificate.
+var certRootFingerprintBody = ast.MustParseBody(`
+	chain := crypto.x509.parse_certificates(trim_space(input.http.client_certificate.intermediates))
+	root := chain[count(chain) - 1]
+	root_fingerprint := crypto.sha256(base64.decode(root.Raw))
+`)
+
+// addCertRootFingerprintCondition adds a condition requiring that the
+// presented chain terminates at a root with one of the given SHA-256
+// fingerprints.
+func addCertRootFingerprintCondition(b *certMatcherBranch, data parser.Value) error {
+	fingerprints, err := parseCertSHA256Fingerprints(data, "root fingerprint")
+	if err != nil {
+		return err
+	}
+
+	b.body = append(b.body, certRootFingerprintBody...)
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("root_fingerprint"), "allowed_root_fingerprints", fingerprints)
+	return nil
+}
+
+// The OCSP responder URLs from the certificate's authority information access
+// extension. Any of them may match, and the host is compared without its port.
+var certAIAOCSPHostBody = ast.MustParseBody(`
+	ocsp_url := cert.OCSPServer[_]
+	ocsp_host := lower(regex.find_all_string_submatch_n(
+		"^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^@/?#:]+)", ocsp_url, 1)[0][1])
+`)
+
+// The CA issuers URLs from the certificate's authority information access
+// extension, where the issuer's certificate may be fetched to build the chain.
+// Like the OCSP responder URLs, any of them may match.
+var certAIACAIssuersHostBody = ast.MustParseBody(`
+	ca_issuers_url := cert.IssuingCertificateURL[_]
+	ca_issuers_host := lower(regex.find_all_string_submatch_n(
+		"^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^@/?#:]+)", ca_issuers_url, 1)[0][1])
+`)
+
+// addCertAIAOCSPHostCondition adds a condition on the host of the
+// certificate's OCSP responder URL.
+func addCertAIAOCSPHostCondition(b *certMatcherBranch, data parser.Value) error {
+	hosts, err := parseCertAIAHosts(data, "aia_ocsp_host", "OCSP host")
+	if err != nil {
+		return err
+	}
+
+	b.body = append(b.body, certAIAOCSPHostBody...)
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("ocsp_host"), "allowed_ocsp_hosts", hosts)
+	return nil
+}
+
+// addCertAIACAIssuersHostCondition adds a condition on the host of the
+// certificate's CA issuers URL.
+func addCertAIACAIssuersHostCondition(b *certMatcherBranch, data parser.Value) error {
+	hosts, err := parseCertAIAHosts(data, "aia_ca_issuers_host", "CA issuers host")
+	if err != nil {
+		return err
+	}
+
+	b.body = append(b.body, certAIACAIssuersHostBody...)
+	addCertAllowedValuesCondition(b, &b.body, ast.VarTerm("ca_issuers_host"), "allowed_ca_issuers_hosts", hosts)
+	return nil
+}
+
+// parseCertAIAHosts returns the lowercase hosts of an authority information
+// access condition, which are host names without a scheme or port.
+func parseCertAIAHosts(data parser.Value, condition, name string) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, fmt.Errorf("certificate %s condition expects a string or array of strings", condition)
+	}
+
+	hosts := make([]string, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("certificate %s must be a string (was %v)", name, v)
+		} else if s == "" {
+			return nil, fmt.Errorf("certificate %s must not be empty", name)
+		} else if strings.ContainsAny(string(s), ":/@") {
+			return nil, fmt.Errorf("certificate %s must be a host name, not a URL (was %s)", name, string(s))
+		}
+		hosts = append(hosts, strings.ToLower(string(s)))
+	}
+	return hosts, nil
+}
+
+// addCertSelfSignedCondition adds a condition on whether the certificate is
+// self-signed, meaning its issuer and subject are identical.
+func addCertSelfSignedCondition(body *ast.Body, data parser.Value) error {
+	b, err := parseCertSelfSigned(data)
+	if err != nil {
+		return err
+	}
+
+	if b {
+		*body = append(*body, ast.MustParseExpr(`cert.RawIssuer == cert.RawSubject`))
+	} else {
+		*body = append(*body, ast.MustParseExpr(`cert.RawIssuer != cert.RawSubject`))
+	}
+	return nil
+}
+
+func parseCertSelfSigned(data parser.Value) (bool, error) {
+	b, ok := data.(parser.Boolean)
+	if !ok {
+		return false, fmt.Errorf("certificate self_signed condition expects a boolean (was %v)", data)
+	}
+	return bool(b), nil
+}
+
+// The authority key identifier extension has the OID 2.5.29.35. Its value is
+// the DER encoding of:
+//
+//	SEQUENCE {
+//	  keyIdentifier             [0] IMPLICIT OCTET STRING OPTIONAL,
+//	  authorityCertIssuer       [1] IMPLICIT GeneralNames OPTIONAL,
+//	  authorityCertSerialNumber [2] IMPLICIT INTEGER OPTIONAL }
+//
+// The sequence is walked past the key identifier and issuer, if any, so that
+// the serial number is only matched as the [2] field, and not, say, as the end
+// of a key identifier.
+var certIssuerSerialBody = ast.MustParseBody(`
+	issuer_serial_extension := cert.Extensions[_]
+	issuer_serial_extension.Id == [2, 5, 29, 35]
+	issuer_serial_aki := hex.encode(base64.decode(issuer_serial_extension.Value))
+	substring(issuer_serial_aki, 0, 2) == "30"
+	issuer_serial_sequence := cert_der_element(issuer_serial_aki, 0)
+	issuer_serial_sequence[1] == count(issuer_serial_aki)
+	issuer_serial_key_id_end := cert_der_skip(issuer_serial_aki, issuer_serial_sequence[0], "80")
+	issuer_serial_issuer_end := cert_der_skip(issuer_serial_aki, issuer_serial_key_id_end, "a1")
+	substring(issuer_serial_aki, issuer_serial_issuer_end, -1) == issuer_serial
+`)
+
+// certDERFunctions walk a DER encoding, as a lowercase hex string, by hex
+// offset. Lengths of up to two bytes are supported, which is plenty for an
+// extension.
+var certDERFunctions = []*ast.Rule{
+	// cert_der_byte is the value of the byte at hex offset i.
+	rules.MustParse(`
+cert_der_byte(v, i) := (indexof("0123456789abcdef", substring(v, i, 1)) * 16) +
+	indexof("0123456789abcdef", substring(v, i + 1, 1)) if {
+	regex.match("^[0-9a-f]{2}$", substring(v, i, 2))
+}
+`),
+	// cert_der_element is the hex offsets of the contents, and of the end, of
+	// the element at hex offset i.
+	rules.MustParse(`
+cert_der_element(v, i) := [i + 4, (i + 4) + (n * 2)] if {
+	n := cert_der_byte(v, i + 2)
+	n < 128
+} else := [i + 6, (i + 6) + (n * 2)] if {
+	cert_der_byte(v, i + 2) == 129
+	n := cert_der_byte(v, i + 4)
+} else := [i + 8, (i + 8) + (n * 2)] if {
+	cert_der_byte(v, i + 2) == 130
+	n := (cert_der_byte(v, i + 4) * 256) + cert_der_byte(v, i + 6)
+}
+`),
+	// cert_der_skip is the hex offset of the end of the element at hex offset
+	// i if it has the given tag, or else i, to skip an optional element.
+	rules.MustParse(`
+cert_der_skip(v, i, tag) := e[1] if {
+	substring(v, i, 2) == tag
+	e := cert_der_element(v, i)
+} else := i
+`),
+}
+
+// The hex form of a serial number, optionally with its bytes separated by
+// colons, like openssl.
+var certSerialNumberHexRE = regexp.MustCompile("^(?:[0-9A-Fa-f]+|[0-9A-Fa-f]{2}(?::[0-9A-Fa-f]{2})*)$")
+
+// addCertIssuerSerialCondition adds a condition requiring that the
+// certificate's authority key identifier names the issuing CA certificate by
+// the given serial number, which pins a particular instance of the CA
+// certificate, rather than just its key:
+//
+//	issuer_serial: "8a:1b:2c:3d"
+//
+// Certificates whose authority key identifier only has a key identifier, as
+// is common, never match.
+func addCertIssuerSerialCondition(_ *Generator, b *certMatcherBranch, _ *[]ast.Body, data parser.Value) error {
+	serial, err := parseCertIssuerSerial(data)
+	if err != nil {
+		return err
+	}
+
+	// authorityCertSerialNumber [2] IMPLICIT CertificateSerialNumber
+	der, err := asn1.Marshal(serial)
+	if err != nil {
+		return err
+	}
+	der[0] = 0x82
+
+	b.body = append(b.body, ast.Assign.Expr(ast.VarTerm("issuer_serial"), ast.StringTerm(hex.EncodeToString(der))))
+	b.body = append(b.body, certIssuerSerialBody...)
+	b.allowedSets = append(b.allowedSets, certDERFunctions...)
+	return nil
+}
+
+// parseCertIssuerSerial returns the serial number of an issuer_serial
+// condition. Like certificate serial numbers, it's hex-encoded, optionally
+// with colons, and compared as a number, so case and leading zeros don't
+// matter. It must be positive and at most 20 bytes.
+func parseCertIssuerSerial(data parser.Value) (*big.Int, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return nil, fmt.Errorf("certificate issuer_serial condition expects a string (was %v)", data)
+	} else if !certSerialNumberHexRE.MatchString(string(s)) {
+		return nil, fmt.Errorf("certificate issuer_serial must be a hex-encoded serial number (was %q)", string(s))
+	}
+
+	serial, _ := new(big.Int).SetString(strings.ReplaceAll(string(s), ":", ""), 16)
+	if serial.Sign() == 0 || serial.BitLen() > 20*8 {
+		return nil, fmt.Errorf("certificate issuer_serial must be positive and at most 20 bytes (was %s)", string(s))
+	}
+	return serial, nil
+}
+
+// certSignatureSchemes are the names of the signature schemes, each of which
+// is the set of signature algorithms using it, whatever the hash. RSA-PSS is
+// distinct from RSA PKCS #1 v1.5, though both use RSA keys.
+var certSignatureSchemes = map[string][]x509.SignatureAlgorithm{
+	"rsa_pkcs1": {
+		x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA,
+		x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+	},
+	"rsa_pss": {x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS},
+	"ecdsa":   {x509.ECDSAWithSHA1, x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512},
+	"ed25519": {x509.PureEd25519},
+}
+
+// addCertSignatureSchemeCondition adds a condition requiring that the
+// certificate is signed with one of the given signature schemes, like:
+//
+//	signature_scheme: rsa_pss
+//
+// A certificate signed with an algorithm that isn't known by name, like DSA,
+// never matches.
+func addCertSignatureSchemeCondition(body *ast.Body, data parser.Value) error {
+	schemes, err := parseCertSignatureSchemes(data)
+	if err != nil {
+		return err
+	}
+
+	set := ast.NewSet()
+	for _, scheme := range schemes {
+		for _, alg := range certSignatureSchemes[scheme] {
+			set.Add(ast.IntNumberTerm(int(alg)))
+		}
+	}
+	*body = append(*body, ast.Member.Expr(ast.VarTerm("cert.SignatureAlgorithm"), ast.NewTerm(set)))
+	return nil
+}
+
+// parseCertSignatureSchemes returns the names of the signature schemes of a
+// signature_scheme condition, which is a name or a list of names.
+func parseCertSignatureSchemes(data parser.Value) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{v}
+	default:
+		return nil, fmt.Errorf("certificate signature_scheme condition expects a string or array of strings (was %v)", data)
+	}
+	if len(pa) == 0 {
+		return nil, errors.New("certificate signature_scheme must not be empty")
+	}
+
+	schemes := make([]string, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("certificate signature scheme must be a string (was %v)", v)
+		} else if _, ok := certSignatureSchemes[string(s)]; !ok {
+			return nil, fmt.Errorf("unsupported certificate signature scheme: %s", string(s))
+		}
+		schemes = append(schemes, string(s))
+	}
+	return schemes, nil
+}
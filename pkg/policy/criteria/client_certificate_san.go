@@ -0,0 +1,865 @@
+package criteria
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/open-policy-agent/opa/ast"
+	"golang.org/x/net/idna"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// A certSAN is a type of subject alternative name in the parsed certificate.
+type certSAN struct {
+	// name identifies the SAN type in the matched_san additional data
+	name string
+	// list is the certificate's list of SANs of this type
+	list string
+}
+
+// URI SANs are matched against the URIStrings which OPA computes when parsing
+// the certificate, rather than reconstructing each URI from its parsed parts
+// (e.g. with sprintf). This keeps the rules simple to partially evaluate: each
+// SAN condition is a plain builtin call on a certificate field.
+var (
+	certSANDNS   = certSAN{name: "dns", list: "cert.DNSNames"}
+	certSANEmail = certSAN{name: "email", list: "cert.EmailAddresses"}
+	certSANURI   = certSAN{name: "uri", list: "cert.URIStrings"}
+	certSANIP    = certSAN{name: "ip", list: "cert.IPAddresses"}
+)
+
+// any returns a term for any SAN of this type.
+func (s certSAN) any() *ast.Term {
+	return ast.VarTerm(s.list + "[_]")
+}
+
+// value returns the variable bound to a single SAN of this type while its
+// conditions are evaluated.
+func (s certSAN) value() *ast.Term {
+	return ast.VarTerm(s.name + "_san")
+}
+
+// matched returns the variable bound to the first SAN of this type which
+// satisfies all of its conditions.
+func (s certSAN) matched() *ast.Term {
+	return ast.VarTerm("matched_" + s.name + "_san")
+}
+
+// validateCertSANStringMatcher returns the error addCertSANCondition would
+// return for the string matcher of a SAN. Unlike the other string matchers, it
+// supports the is_not operator.
+func validateCertSANStringMatcher(data parser.Value) error {
+	if obj, ok := data.(parser.Object); ok {
+		if _, ok := obj["is_not"]; ok {
+			obj = obj.Clone().(parser.Object)
+			delete(obj, "is_not")
+			data = obj
+		}
+	}
+	return validateStringMatcher(data)
+}
+
+// addCertSANCondition adds a string matcher condition over a list of SANs,
+// along with any additional conditions on san.value(). All of the conditions
+// must be satisfied by a single SAN, and the first such SAN is bound to
+// san.matched(). The is_not operator adds a deny body matching a certificate
+// with that SAN.
+func addCertSANCondition(
+	b *certMatcherBranch, deny *[]ast.Body, san certSAN, conditions ast.Body, data parser.Value,
+) error {
+	data, optional, err := splitCertSANOptional(data)
+	if err != nil {
+		return err
+	}
+
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return matchString(&b.body, san.any(), data)
+	}
+
+	if v, ok := obj["is_not"]; ok {
+		denyBody := append(ast.Body(nil), clientCertificateBaseBody...)
+		err := matchStringIs(&denyBody, san.any(), v)
+		if err != nil {
+			return err
+		}
+		*deny = append(*deny, denyBody)
+
+		obj = obj.Clone().(parser.Object)
+		delete(obj, "is_not")
+	}
+
+	err = matchString(&conditions, san.value(), obj)
+	if err != nil {
+		return err
+	}
+
+	// a lone is_not doesn't require the certificate to have any SANs
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	matches := ast.VarTerm("matched_" + san.name + "_sans")
+	b.body = append(b.body,
+		ast.Assign.Expr(matches, ast.ArrayComprehensionTerm(san.value(),
+			append(ast.Body{ast.Assign.Expr(san.value(), san.any())}, conditions...))))
+	if optional {
+		// either some SAN matches, or there are no SANs of this type, in which
+		// case the matched SAN is null
+		b.body = append(b.body,
+			ast.GreaterThanEq.Expr(ast.Count.Call(matches), ast.Min.Call(ast.ArrayTerm(
+				ast.Count.Call(ast.ArrayComprehensionTerm(san.value(),
+					ast.Body{ast.Assign.Expr(san.value(), san.any())})),
+				ast.IntNumberTerm(1)))),
+			ast.Assign.Expr(san.matched(), ast.RefTerm(
+				ast.ArrayConcat.Call(matches, ast.ArrayTerm(ast.NullTerm())),
+				ast.IntNumberTerm(0))))
+	} else {
+		b.body = append(b.body,
+			ast.Assign.Expr(san.matched(), ast.RefTerm(matches, ast.IntNumberTerm(0))))
+	}
+	b.matchedSANs = append(b.matchedSANs, san)
+	return nil
+}
+
+// splitCertSANOptional removes the optional modifier from a SAN matcher. An
+// optional SAN condition is skipped when the certificate has no SANs of its
+// type, but must still match if there are any.
+//
+// The optional modifier only applies to its own condition. The other
+// conditions of the matcher are still required, so a matcher with an optional
+// email SAN and a fingerprint always requires the fingerprint to match.
+func splitCertSANOptional(data parser.Value) (parser.Value, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, false, nil
+	}
+
+	v, ok := obj["optional"]
+	if !ok {
+		return data, false, nil
+	}
+
+	optional, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, false, fmt.Errorf("certificate SAN optional expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "optional")
+	return obj, bool(optional), nil
+}
+
+// addCertSANDNSCondition adds a string matcher condition over the DNS SANs.
+// Since DNS names are case-insensitive, contains and ends_with compare
+// lowercase values. ends_with also accepts a list of suffixes, any of which
+// may match. in_reverse_zone matches PTR-style names within a reverse DNS
+// zone, like 4.3.2.10.in-addr.arpa within 10.in-addr.arpa. forbid_wildcard
+// rejects certificates with any wildcard DNS SAN, and require_fqdn requires
+// the DNS SANs to all be, or with false to all not be, fully-qualified.
+// equals_request_host requires a DNS SAN to be the host of the request,
+// case-insensitively and without its port.
+func addCertSANDNSCondition(b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+	var conditions ast.Body
+
+	data, equalsRequestHost, err := splitCertSANDNSEqualsRequestHost(data)
+	if err != nil {
+		return err
+	}
+	if equalsRequestHost {
+		b.body = append(b.body, certRequestHostBody...)
+		conditions = append(conditions,
+			ast.Equal.Expr(ast.Lower.Call(certSANDNS.value()), ast.VarTerm("request_host")))
+	}
+
+	data, forbidWildcard, err := splitCertSANDNSForbidWildcard(data)
+	if err != nil {
+		return err
+	}
+	data, requireFQDN, err := splitCertSANDNSRequireFQDN(data)
+	if err != nil {
+		return err
+	}
+	if requireFQDN != nil {
+		// count the names of the wrong form, which must be none
+		fqdn := ast.EndsWith.Expr(ast.VarTerm("fqdn_dns_san"), ast.StringTerm("."))
+		fqdn.Negated = *requireFQDN
+		b.body = append(b.body, ast.Equal.Expr(
+			ast.Count.Call(ast.ArrayComprehensionTerm(ast.VarTerm("fqdn_dns_san"), ast.Body{
+				ast.Assign.Expr(ast.VarTerm("fqdn_dns_san"), certSANDNS.any()),
+				fqdn,
+			})),
+			ast.IntNumberTerm(0)))
+	}
+	if forbidWildcard {
+		b.body = append(b.body, ast.Equal.Expr(
+			ast.Count.Call(ast.ArrayComprehensionTerm(ast.VarTerm("wildcard_dns_san"), ast.Body{
+				ast.Assign.Expr(ast.VarTerm("wildcard_dns_san"), certSANDNS.any()),
+				ast.StartsWith.Expr(ast.VarTerm("wildcard_dns_san"), ast.StringTerm("*.")),
+			})),
+			ast.IntNumberTerm(0)))
+	}
+
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return addCertSANCondition(b, deny, certSANDNS, conditions, data)
+	}
+
+	if v, ok := obj["ends_with"]; ok {
+		parsed, err := parseCertSANDNSSuffixes(v)
+		if err != nil {
+			return err
+		}
+
+		suffixes := ast.NewArray()
+		for _, s := range parsed {
+			suffixes = suffixes.Append(ast.StringTerm(s))
+		}
+
+		conditions = append(conditions,
+			ast.AnySuffixMatch.Expr(ast.Lower.Call(certSANDNS.value()), ast.NewTerm(suffixes)))
+
+		obj = obj.Clone().(parser.Object)
+		delete(obj, "ends_with")
+	}
+
+	if v, ok := obj["contains"]; ok {
+		substr, err := parseCertSANDNSSubstring(v)
+		if err != nil {
+			return err
+		}
+
+		conditions = append(conditions,
+			ast.Contains.Expr(ast.Lower.Call(certSANDNS.value()), ast.StringTerm(substr)))
+
+		obj = obj.Clone().(parser.Object)
+		delete(obj, "contains")
+	}
+
+	if v, ok := obj["in_reverse_zone"]; ok {
+		zone, err := parseCertSANDNSReverseZone(v)
+		if err != nil {
+			return err
+		}
+
+		// the name is within the zone if it's the zone or a subdomain of it
+		conditions = append(conditions, ast.EndsWith.Expr(
+			ast.Concat.Call(ast.StringTerm(""), ast.ArrayTerm(
+				ast.StringTerm("."), ast.Lower.Call(certSANDNS.value()))),
+			ast.StringTerm("."+zone)))
+
+		obj = obj.Clone().(parser.Object)
+		delete(obj, "in_reverse_zone")
+	}
+
+	return addCertSANCondition(b, deny, certSANDNS, conditions, obj)
+}
+
+// The host of the request is its hostname, without a port, as the authorize
+// evaluator sets it. A request without a host never matches.
+var certRequestHostBody = ast.MustParseBody(`
+	request_host := lower(object.get(input.http, "hostname", ""))
+`)
+
+// splitCertSANDNSEqualsRequestHost removes the equals_request_host operator
+// from a DNS SAN matcher, e.g. for device certificates issued for the host
+// they connect to. Wildcard DNS SANs aren't expanded, so *.example.com
+// doesn't match a request to www.example.com.
+func splitCertSANDNSEqualsRequestHost(data parser.Value) (parser.Value, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, false, nil
+	}
+
+	v, ok := obj["equals_request_host"]
+	if !ok {
+		return data, false, nil
+	}
+
+	equalsRequestHost, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, false, fmt.Errorf("certificate SAN DNS equals_request_host expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "equals_request_host")
+	return obj, bool(equalsRequestHost), nil
+}
+
+// splitCertSANDNSForbidWildcard removes the forbid_wildcard operator from a
+// DNS SAN matcher. It requires that none of the DNS SANs are wildcards, like
+// *.example.com, whether or not any other operators match them.
+func splitCertSANDNSForbidWildcard(data parser.Value) (parser.Value, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, false, nil
+	}
+
+	v, ok := obj["forbid_wildcard"]
+	if !ok {
+		return data, false, nil
+	}
+
+	forbidWildcard, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, false, fmt.Errorf("certificate SAN DNS forbid_wildcard expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "forbid_wildcard")
+	return obj, bool(forbidWildcard), nil
+}
+
+// splitCertSANDNSRequireFQDN removes the require_fqdn operator from a DNS SAN
+// matcher, returning nil if it's absent. A DNS SAN is fully-qualified if it
+// ends with a dot, like www.example.com., and names aren't normalized, so
+// with require_fqdn: true a name must be matched with its trailing dot. With
+// true all of the DNS SANs must be fully-qualified, and with false none of
+// them may be, whether or not any other operators match them.
+func splitCertSANDNSRequireFQDN(data parser.Value) (parser.Value, *bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, nil, nil
+	}
+
+	v, ok := obj["require_fqdn"]
+	if !ok {
+		return data, nil, nil
+	}
+
+	requireFQDN, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate SAN DNS require_fqdn expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "require_fqdn")
+	b := bool(requireFQDN)
+	return obj, &b, nil
+}
+
+// parseCertSANDNSSuffixes returns the lowercase suffixes of a DNS SAN
+// ends_with operator.
+func parseCertSANDNSSuffixes(data parser.Value) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{v}
+	default:
+		return nil, errors.New("certificate SAN DNS ends_with expects a string or array of strings")
+	}
+
+	suffixes := make([]string, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("certificate SAN DNS suffix must be a string (was %v)", v)
+		} else if s == "" {
+			return nil, errors.New("certificate SAN DNS suffix must not be empty")
+		}
+		suffixes = append(suffixes, strings.ToLower(string(s)))
+	}
+	return suffixes, nil
+}
+
+// parseCertSANDNSSubstring returns the lowercase substring of a DNS SAN
+// contains operator.
+func parseCertSANDNSSubstring(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate SAN DNS contains expects a string (was %v)", data)
+	} else if s == "" {
+		return "", errors.New("certificate SAN DNS contains must not be empty")
+	}
+	return strings.ToLower(string(s)), nil
+}
+
+func validateCertSANDNSMatcher(data parser.Value) error {
+	data, _, err := splitCertSANDNSEqualsRequestHost(data)
+	if err != nil {
+		return err
+	}
+	data, _, err = splitCertSANDNSForbidWildcard(data)
+	if err != nil {
+		return err
+	}
+	data, _, err = splitCertSANDNSRequireFQDN(data)
+	if err != nil {
+		return err
+	}
+	if obj, ok := data.(parser.Object); ok {
+		if v, ok := obj["ends_with"]; ok {
+			_, err := parseCertSANDNSSuffixes(v)
+			if err != nil {
+				return err
+			}
+		}
+		if v, ok := obj["contains"]; ok {
+			_, err := parseCertSANDNSSubstring(v)
+			if err != nil {
+				return err
+			}
+		}
+		if v, ok := obj["in_reverse_zone"]; ok {
+			_, err := parseCertSANDNSReverseZone(v)
+			if err != nil {
+				return err
+			}
+			obj = obj.Clone().(parser.Object)
+			delete(obj, "in_reverse_zone")
+			data = obj
+		}
+	}
+	return validateCertSANStringMatcher(data)
+}
+
+// addCertSANURICondition adds a string matcher condition over the URI SANs.
+// URI SANs also support the matches operator, a regular expression which
+// must match the whole URI, and the host operator, which compares only the
+// host of the URI, case-insensitively, whatever its scheme, port or path.
+func addCertSANURICondition(b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+	var conditions ast.Body
+
+	data, schemeCount, err := splitCertSANURISchemeCount(data)
+	if err != nil {
+		return err
+	}
+	if schemeCount != nil {
+		b.body = append(b.body, ast.Equal.Expr(
+			ast.Count.Call(ast.ArrayComprehensionTerm(ast.VarTerm("scheme_uri_san"), ast.Body{
+				ast.Assign.Expr(ast.VarTerm("scheme_uri_san"), certSANURI.any()),
+				ast.StartsWith.Expr(ast.Lower.Call(ast.VarTerm("scheme_uri_san")),
+					ast.StringTerm(schemeCount.scheme+":")),
+			})),
+			ast.IntNumberTerm(schemeCount.count)))
+	}
+
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return addCertSANCondition(b, deny, certSANURI, conditions, data)
+	}
+
+	if v, ok := obj["matches"]; ok {
+		pattern, err := parseCertSANURIPattern(v)
+		if err != nil {
+			return err
+		}
+
+		conditions = append(conditions,
+			ast.RegexMatch.Expr(ast.StringTerm(pattern), certSANURI.value()))
+
+		obj = obj.Clone().(parser.Object)
+		delete(obj, "matches")
+	}
+
+	if v, ok := obj["host"]; ok {
+		host, err := parseCertSANURIHost(v)
+		if err != nil {
+			return err
+		}
+
+		// a URI without an authority, like a URN, has no host and so never matches
+		conditions = append(conditions, ast.Equal.Expr(
+			ast.Lower.Call(ast.RefTerm(
+				ast.RegexFindAllStringSubmatch.Call(
+					ast.StringTerm(certURIHostPattern), certSANURI.value(), ast.IntNumberTerm(1)),
+				ast.IntNumberTerm(0), ast.IntNumberTerm(1))),
+			ast.StringTerm(host)))
+
+		obj = obj.Clone().(parser.Object)
+		delete(obj, "host")
+	}
+
+	return addCertSANCondition(b, deny, certSANURI, conditions, obj)
+}
+
+// certURIHostPattern captures the host of an absolute URI, without any
+// userinfo or port.
+const certURIHostPattern = "^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^@/?#:]+)"
+
+// parseCertSANURIHost returns the lowercase host of a URI SAN host operator,
+// which is a host name without a scheme or port.
+func parseCertSANURIHost(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate SAN URI host expects a string (was %v)", data)
+	} else if s == "" {
+		return "", errors.New("certificate SAN URI host must not be empty")
+	} else if strings.ContainsAny(string(s), ":/@") {
+		return "", fmt.Errorf("certificate SAN URI host must be a host name, not a URL (was %s)", string(s))
+	}
+	return strings.ToLower(string(s)), nil
+}
+
+// A certSANURISchemeCount is the number of URI SANs with a scheme which a
+// certificate must have.
+type certSANURISchemeCount struct {
+	scheme string
+	count  int
+}
+
+// A URI scheme, as in RFC 3986.
+var certSANURISchemeRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*$`)
+
+// splitCertSANURISchemeCount removes the scheme and count operators from a
+// URI SAN matcher, like {scheme: spiffe, count: 1}, which requires exactly
+// count URI SANs with the scheme. Schemes compare case-insensitively. The
+// operators must be used together, and count may be 0 to forbid URI SANs with
+// the scheme. Any other operators of the matcher apply as usual.
+func splitCertSANURISchemeCount(data parser.Value) (parser.Value, *certSANURISchemeCount, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, nil, nil
+	}
+
+	scheme, hasScheme := obj["scheme"]
+	count, hasCount := obj["count"]
+	if !hasScheme && !hasCount {
+		return data, nil, nil
+	} else if !hasScheme || !hasCount {
+		return nil, nil, errors.New("certificate SAN URI scheme and count must be used together")
+	}
+
+	s, ok := scheme.(parser.String)
+	if !ok || !certSANURISchemeRE.MatchString(string(s)) {
+		return nil, nil, fmt.Errorf("certificate SAN URI scheme expects a URI scheme, like spiffe (was %v)", scheme)
+	}
+	n, ok := count.(parser.Number)
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate SAN URI count expects an integer (was %v)", count)
+	}
+	c, err := strconv.Atoi(string(n))
+	if err != nil || c < 0 {
+		return nil, nil, fmt.Errorf("certificate SAN URI count expects a non-negative integer (was %s)", string(n))
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "scheme")
+	delete(obj, "count")
+	return obj, &certSANURISchemeCount{scheme: strings.ToLower(string(s)), count: c}, nil
+}
+
+// parseCertSANURIPattern returns the anchored form of a URI SAN matches
+// pattern.
+func parseCertSANURIPattern(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate SAN URI matches expects a string (was %v)", data)
+	}
+
+	pattern := "^(?:" + string(s) + ")$"
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", fmt.Errorf("invalid certificate SAN URI pattern (%s): %w", string(s), err)
+	}
+	return pattern, nil
+}
+
+func validateCertSANURIMatcher(data parser.Value) error {
+	data, _, err := splitCertSANURISchemeCount(data)
+	if err != nil {
+		return err
+	}
+	if obj, ok := data.(parser.Object); ok {
+		if v, ok := obj["matches"]; ok {
+			_, err := parseCertSANURIPattern(v)
+			if err != nil {
+				return err
+			}
+
+			obj = obj.Clone().(parser.Object)
+			delete(obj, "matches")
+			data = obj
+		}
+		if v, ok := obj["host"]; ok {
+			_, err := parseCertSANURIHost(v)
+			if err != nil {
+				return err
+			}
+
+			obj = obj.Clone().(parser.Object)
+			delete(obj, "host")
+			data = obj
+		}
+	}
+	return validateCertSANStringMatcher(data)
+}
+
+// addCertSANDenylistCondition denies certificates with an email or DNS SAN in
+// one of the named denylists supplied to the Generator. Entries containing an
+// @ are email addresses, and the rest DNS names, which compare
+// case-insensitively.
+func addCertSANDenylistCondition(
+	b *certMatcherBranch, deny *[]ast.Body, data parser.Value, lookupDenylist func(name string) ([]string, bool),
+) error {
+	names, err := parseCertSANDenylistNames(data)
+	if err != nil {
+		return err
+	}
+
+	var emails, dnsNames []string
+	for _, name := range names {
+		denylist, ok := lookupDenylist(name)
+		if !ok {
+			return fmt.Errorf("certificate SAN denylist is not set: %s", name)
+		}
+		for _, san := range denylist {
+			if strings.Contains(san, "@") {
+				email, err := normalizeEmailDomain(san)
+				if err != nil {
+					return err
+				}
+				emails = append(emails, email)
+			} else if san != "" {
+				dnsNames = append(dnsNames, strings.ToLower(san))
+			}
+		}
+	}
+
+	if len(emails) > 0 {
+		body := append(ast.Body(nil), clientCertificateBaseBody...)
+		addCertAllowedValuesCondition(b, &body, certSANEmail.any(), "denied_email_sans", emails)
+		*deny = append(*deny, body)
+	}
+	if len(dnsNames) > 0 {
+		body := append(ast.Body(nil), clientCertificateBaseBody...)
+		addCertAllowedValuesCondition(b, &body, ast.Lower.Call(certSANDNS.any()), "denied_dns_sans", dnsNames)
+		*deny = append(*deny, body)
+	}
+	return nil
+}
+
+// parseCertSANDenylistNames returns the names of the denylists referenced by
+// a san_denylist condition.
+func parseCertSANDenylistNames(data parser.Value) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, errors.New("certificate san_denylist condition expects a string or array of strings")
+	}
+
+	names := make([]string, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("certificate SAN denylist name must be a string (was %v)", v)
+		} else if s == "" {
+			return nil, errors.New("certificate SAN denylist name must not be empty")
+		}
+		names = append(names, string(s))
+	}
+	return names, nil
+}
+
+// normalizeEmailDomain converts an internationalized domain name in an email
+// address to its ASCII (punycode) form. The local part is left unchanged.
+func normalizeEmailDomain(email string) (string, error) {
+	idx := strings.LastIndex(email, "@")
+	if idx < 0 {
+		return email, nil
+	}
+
+	local, domain := email[:idx], email[idx+1:]
+	if isASCII(domain) {
+		return email, nil
+	}
+
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid email domain (%s): %w", email[idx+1:], err)
+	}
+	return local + "@" + domain, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// The subject alternative name extension has the OID 2.5.29.17, and its value
+// is the DER encoding of a sequence of GeneralNames. A GeneralName is found
+// at a byte boundary, i.e. an even offset, of the hex-encoded extension.
+var certSANRawBody = ast.MustParseBody(`
+	san_raw_extension := cert.Extensions[_]
+	san_raw_extension.Id == [2, 5, 29, 17]
+	san_raw_index := indexof_n(hex.encode(base64.decode(san_raw_extension.Value)), san_raw_name)[_]
+	san_raw_index % 2 == 0
+`)
+
+// addCertSANRawCondition adds a condition requiring that the certificate has
+// an otherName SAN, which OPA doesn't decode, e.g. for a custom SAN type:
+//
+//	san_raw:
+//	  oid: 1.3.6.1.4.1.311.20.2.3
+//	  value_base64: DAVhbGljZQ==
+//
+// The oid is the otherName's type-id, and value_base64 the DER encoding of its
+// value, here the UTF8String "alice". The otherName's encoding is matched
+// exactly against the raw bytes of the SAN extension, without parsing the
+// extension, so it would also match those bytes nested within another SAN.
+func addCertSANRawCondition(body *ast.Body, data parser.Value) error {
+	name, err := parseCertSANRaw(data)
+	if err != nil {
+		return err
+	}
+
+	*body = append(*body, ast.Assign.Expr(ast.VarTerm("san_raw_name"), ast.StringTerm(hex.EncodeToString(name))))
+	*body = append(*body, certSANRawBody...)
+	return nil
+}
+
+// parseCertSANRaw returns the DER encoding of the otherName GeneralName of a
+// san_raw condition.
+func parseCertSANRaw(data parser.Value) ([]byte, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, fmt.Errorf("expected object for certificate san_raw condition, got: %T", data)
+	}
+	for k := range obj {
+		if k != "oid" && k != "value_base64" {
+			return nil, fmt.Errorf("unsupported certificate san_raw field: %s", k)
+		}
+	}
+
+	oid, err := parseCertSANRawOID(obj["oid"])
+	if err != nil {
+		return nil, parser.ErrorAt(err, "oid")
+	}
+
+	s, ok := obj["value_base64"].(parser.String)
+	if !ok {
+		return nil, parser.ErrorAt(
+			fmt.Errorf("certificate san_raw value_base64 expects a string (was %v)", obj["value_base64"]), "value_base64")
+	}
+	value, err := base64.StdEncoding.DecodeString(string(s))
+	if err != nil {
+		return nil, parser.ErrorAt(
+			fmt.Errorf("invalid certificate san_raw value_base64 (%s): %w", string(s), err), "value_base64")
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) > 0 {
+		return nil, parser.ErrorAt(
+			fmt.Errorf("certificate san_raw value_base64 must be a single DER-encoded value (was %s)", string(s)),
+			"value_base64")
+	}
+
+	// otherName ::= [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+	typeID, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, parser.ErrorAt(err, "oid")
+	}
+	explicitValue, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(typeID, explicitValue...),
+	})
+}
+
+// parseCertSANRawOID parses the dotted form of an object identifier, like
+// 1.3.6.1.4.1.311.20.2.3.
+func parseCertSANRawOID(data parser.Value) (asn1.ObjectIdentifier, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return nil, fmt.Errorf("certificate san_raw oid expects a string (was %v)", data)
+	}
+
+	parts := strings.Split(string(s), ".")
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 31)
+		if err != nil || (len(part) > 1 && part[0] == '0') {
+			return nil, fmt.Errorf("invalid certificate san_raw oid: %q", string(s))
+		}
+		oid = append(oid, int(n))
+	}
+	if len(oid) < 2 || oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("invalid certificate san_raw oid: %q", string(s))
+	}
+	return oid, nil
+}
+
+// The SAN lists may be null, so they're counted via comprehensions.
+var certTotalSANCountBody = ast.MustParseBody(`
+	total_san_count := ((count([x | x := cert.DNSNames[_]]) +
+		count([x | x := cert.IPAddresses[_]])) +
+		count([x | x := cert.EmailAddresses[_]])) +
+		count([x | x := cert.URIStrings[_]])
+`)
+
+// addCertMaxTotalSANCondition adds a limit on the total number of SANs of any
+// type (DNS, IP, email and URI) in the certificate.
+func addCertMaxTotalSANCondition(body *ast.Body, data parser.Value) error {
+	n, err := parseCertMaxTotalSAN(data)
+	if err != nil {
+		return err
+	}
+
+	*body = append(*body, certTotalSANCountBody...)
+	*body = append(*body,
+		ast.LessThanEq.Expr(ast.VarTerm("total_san_count"), ast.IntNumberTerm(n)))
+	return nil
+}
+
+func parseCertMaxTotalSAN(data parser.Value) (int, error) {
+	v, ok := data.(parser.Number)
+	if !ok {
+		return 0, fmt.Errorf("certificate max_total_san condition expects an integer (was %v)", data)
+	}
+
+	n, err := strconv.Atoi(string(v))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("certificate max_total_san condition expects a non-negative integer "+
+			"(was %s)", string(v))
+	}
+	return n, nil
+}
+
+// The SAN lists may be null, so they're counted via comprehensions.
+var certIPOnlyBody = ast.MustParseBody(`
+	count([x | x := cert.DNSNames[_]]) == 0
+	count([x | x := cert.IPAddresses[_]]) > 0
+`)
+
+// addCertIPOnlyCondition adds a condition requiring that the certificate
+// identifies its subject by IP address alone: it has at least one IP SAN and
+// no DNS SANs. If false there is no requirement.
+func addCertIPOnlyCondition(body *ast.Body, data parser.Value) error {
+	b, err := parseCertIPOnly(data)
+	if err != nil {
+		return err
+	}
+
+	if b {
+		*body = append(*body, certIPOnlyBody...)
+	}
+	return nil
+}
+
+func parseCertIPOnly(data parser.Value) (bool, error) {
+	b, ok := data.(parser.Boolean)
+	if !ok {
+		return false, fmt.Errorf("certificate ip_only condition expects a boolean (was %v)", data)
+	}
+	return bool(b), nil
+}
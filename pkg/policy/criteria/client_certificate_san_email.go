@@ -0,0 +1,487 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+	"golang.org/x/net/idna"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The domain of the session user's email address, in its ASCII form, so that
+// it compares with an email SAN's domain whichever form of an internationalized
+// domain name each has. Without a session email there is no domain, and so no
+// email SAN can match it.
+var certSessionEmailDomainBody = ast.MustParseBody(`
+	session := get_session(input.session.id)
+	user := get_user(session)
+	session_email := get_user_email(session, user)
+	contains(session_email, "@")
+	session_email_domain := idna_to_ascii(regex.replace(session_email, "^.*@", ""))
+`)
+
+// IDNAToASCIIRegoOption defines the idna_to_ascii function used by the
+// client_certificate criterion, which converts a domain name to its lowercase
+// ASCII (punycode) form, and is undefined for an invalid internationalized
+// domain name.
+var IDNAToASCIIRegoOption = rego.Function1(&rego.Function{
+	Name: "idna_to_ascii",
+	Decl: types.NewFunction(types.Args(types.S), types.S),
+}, func(_ rego.BuiltinContext, op1 *ast.Term) (*ast.Term, error) {
+	domain, ok := op1.Value.(ast.String)
+	if !ok {
+		return nil, fmt.Errorf("invalid domain type: %T", op1)
+	}
+
+	if isASCII(string(domain)) {
+		return ast.StringTerm(strings.ToLower(string(domain))), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(string(domain))
+	if err != nil {
+		return nil, nil
+	}
+	return ast.StringTerm(strings.ToLower(ascii)), nil
+})
+
+// The claims of the session. Without a session there are no claims, and so
+// no email SAN can be in one of them.
+var certSessionClaimsBody = ast.MustParseBody(`
+	claim_session := get_session(input.session.id)
+	session_claims := object.get(claim_session, "claims", {})
+`)
+
+// The session's sub claims which are email addresses. Most identity providers
+// use an opaque sub, which never matches an email SAN.
+var certSessionSubEmailsBody = ast.MustParseBody(`
+	session_sub_emails := [sub | sub := object.get(session_claims, "sub", [])[_]; contains(sub, "@")]
+`)
+
+// addCertSANEmailCondition adds a string matcher condition over the email
+// SANs. Email SANs also support the same_domain_as_session operator, which
+// requires the domain of the SAN to be the domain of the logged-in user's
+// email address, the in_session_claim operator, which requires the SAN to be
+// one of the values of the named session claim, the equals_session_sub
+// operator, which requires the SAN to be the session's sub claim, the
+// local_part operator, which requires the part of the SAN before the @ to
+// match exactly, whatever its domain, and the domain_in operator, which
+// requires the domain of the SAN to be one of a list of domains,
+// case-insensitively. The count operator requires exactly that many email
+// SANs, whatever the other operators.
+func addCertSANEmailCondition(b *certMatcherBranch, deny *[]ast.Body, data parser.Value) error {
+	data, sameDomain, err := splitCertSANEmailSameDomain(data)
+	if err != nil {
+		return err
+	}
+	data, claim, err := splitCertSANEmailInSessionClaim(data)
+	if err != nil {
+		return err
+	}
+	data, equalsSub, err := splitCertSANEmailEqualsSessionSub(data)
+	if err != nil {
+		return err
+	}
+	data, localPart, err := splitCertSANEmailLocalPart(data)
+	if err != nil {
+		return err
+	}
+	data, domainGlob, err := splitCertSANEmailDomainGlob(data)
+	if err != nil {
+		return err
+	}
+	data, domains, err := splitCertSANEmailDomainIn(data)
+	if err != nil {
+		return err
+	}
+	data, singleDomain, err := splitCertSANEmailSingleDomain(data)
+	if err != nil {
+		return err
+	}
+	data, emailCount, err := splitCertSANEmailCount(data)
+	if err != nil {
+		return err
+	}
+
+	if emailCount != nil {
+		b.body = append(b.body, ast.Equal.Expr(
+			ast.Count.Call(ast.ArrayComprehensionTerm(ast.VarTerm("counted_email_san"),
+				ast.Body{ast.Assign.Expr(ast.VarTerm("counted_email_san"), certSANEmail.any())})),
+			ast.IntNumberTerm(*emailCount)))
+	}
+
+	if singleDomain {
+		b.body = append(b.body, ast.Equal.Expr(
+			ast.Count.Call(ast.SetComprehensionTerm(
+				ast.Lower.Call(ast.RegexReplace.Call(
+					ast.VarTerm("single_domain_email_san"), ast.StringTerm("^.*@"), ast.StringTerm(""))),
+				ast.Body{ast.Assign.Expr(ast.VarTerm("single_domain_email_san"), certSANEmail.any())})),
+			ast.IntNumberTerm(1)))
+	}
+
+	var conditions ast.Body
+	if localPart != "" {
+		// local parts are case-sensitive, so they're compared as is
+		conditions = append(conditions, ast.Equal.Expr(
+			ast.RegexReplace.Call(certSANEmail.value(), ast.StringTerm("@[^@]*$"), ast.StringTerm("")),
+			ast.StringTerm(localPart)))
+	}
+	if domainGlob != "" {
+		conditions = append(conditions, ast.GlobMatch.Expr(
+			ast.StringTerm(domainGlob),
+			ast.ArrayTerm(ast.StringTerm(".")),
+			ast.Lower.Call(ast.RegexReplace.Call(
+				certSANEmail.value(), ast.StringTerm("^.*@"), ast.StringTerm("")))))
+	}
+	if len(domains) > 0 {
+		terms := make([]*ast.Term, 0, len(domains))
+		for _, domain := range domains {
+			terms = append(terms, ast.StringTerm(domain))
+		}
+		conditions = append(conditions, ast.Member.Expr(
+			ast.Lower.Call(ast.RegexReplace.Call(
+				certSANEmail.value(), ast.StringTerm("^.*@"), ast.StringTerm(""))),
+			ast.SetTerm(terms...)))
+	}
+	if sameDomain {
+		b.body = append(b.body, certSessionEmailDomainBody...)
+		b.usesSession = true
+		conditions = append(conditions, ast.Equal.Expr(
+			ast.CallTerm(ast.RefTerm(ast.VarTerm("idna_to_ascii")), ast.RegexReplace.Call(
+				certSANEmail.value(), ast.StringTerm("^.*@"), ast.StringTerm(""))),
+			ast.VarTerm("session_email_domain")))
+	}
+	if claim != "" || equalsSub {
+		b.body = append(b.body, certSessionClaimsBody...)
+		b.usesSession = true
+	}
+	if claim != "" {
+		b.body = append(b.body, ast.Assign.Expr(ast.VarTerm("session_claim_emails"),
+			ast.ObjectGet.Call(ast.VarTerm("session_claims"), ast.StringTerm(claim), ast.ArrayTerm())))
+		// emails are compared as is, like the claim criterion compares values
+		conditions = append(conditions, ast.Equal.Expr(
+			certSANEmail.value(), ast.VarTerm("session_claim_emails[_]")))
+	}
+	if equalsSub {
+		b.body = append(b.body, certSessionSubEmailsBody...)
+		conditions = append(conditions, ast.Equal.Expr(
+			certSANEmail.value(), ast.VarTerm("session_sub_emails[_]")))
+	}
+
+	normalized, err := normalizeCertSANEmailMatcher(data)
+	if err != nil {
+		return err
+	}
+	return addCertSANCondition(b, deny, certSANEmail, conditions, normalized)
+}
+
+// validateCertSANEmailMatcher returns the error addCertSANEmailCondition
+// would return for the matcher.
+func validateCertSANEmailMatcher(data parser.Value) error {
+	data, _, err := splitCertSANEmailSameDomain(data)
+	if err == nil {
+		data, _, err = splitCertSANEmailInSessionClaim(data)
+	}
+	if err == nil {
+		data, _, err = splitCertSANEmailEqualsSessionSub(data)
+	}
+	if err == nil {
+		data, _, err = splitCertSANEmailLocalPart(data)
+	}
+	if err == nil {
+		data, _, err = splitCertSANEmailDomainGlob(data)
+	}
+	if err == nil {
+		data, _, err = splitCertSANEmailDomainIn(data)
+	}
+	if err == nil {
+		data, _, err = splitCertSANEmailSingleDomain(data)
+	}
+	if err == nil {
+		data, _, err = splitCertSANEmailCount(data)
+	}
+	if err == nil {
+		_, err = normalizeCertSANEmailMatcher(data)
+	}
+	if err != nil {
+		return err
+	}
+	return validateCertSANStringMatcher(data)
+}
+
+// splitCertSANEmailSameDomain removes the same_domain_as_session operator
+// from an email SAN matcher.
+func splitCertSANEmailSameDomain(data parser.Value) (parser.Value, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, false, nil
+	}
+
+	v, ok := obj["same_domain_as_session"]
+	if !ok {
+		return data, false, nil
+	}
+
+	sameDomain, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, false, fmt.Errorf("certificate SAN email same_domain_as_session expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "same_domain_as_session")
+	return obj, bool(sameDomain), nil
+}
+
+// splitCertSANEmailInSessionClaim removes the in_session_claim operator from
+// an email SAN matcher, returning the name of the claim.
+func splitCertSANEmailInSessionClaim(data parser.Value) (parser.Value, string, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, "", nil
+	}
+
+	v, ok := obj["in_session_claim"]
+	if !ok {
+		return data, "", nil
+	}
+
+	claim, ok := v.(parser.String)
+	if !ok {
+		return nil, "", fmt.Errorf("certificate SAN email in_session_claim expects a string (was %v)", v)
+	} else if claim == "" {
+		return nil, "", errors.New("certificate SAN email in_session_claim must not be empty")
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "in_session_claim")
+	return obj, string(claim), nil
+}
+
+// splitCertSANEmailEqualsSessionSub removes the equals_session_sub operator
+// from an email SAN matcher.
+func splitCertSANEmailEqualsSessionSub(data parser.Value) (parser.Value, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, false, nil
+	}
+
+	v, ok := obj["equals_session_sub"]
+	if !ok {
+		return data, false, nil
+	}
+
+	equalsSub, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, false, fmt.Errorf("certificate SAN email equals_session_sub expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "equals_session_sub")
+	return obj, bool(equalsSub), nil
+}
+
+// splitCertSANEmailSingleDomain removes the single_domain operator from an
+// email SAN matcher. It requires that all of the email SANs have the same
+// domain, e.g. so that a certificate can't span tenants, and so that there's
+// at least one email SAN. Domains compare case-insensitively.
+func splitCertSANEmailSingleDomain(data parser.Value) (parser.Value, bool, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, false, nil
+	}
+
+	v, ok := obj["single_domain"]
+	if !ok {
+		return data, false, nil
+	}
+
+	singleDomain, ok := v.(parser.Boolean)
+	if !ok {
+		return nil, false, fmt.Errorf("certificate SAN email single_domain expects a boolean (was %v)", v)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "single_domain")
+	return obj, bool(singleDomain), nil
+}
+
+// splitCertSANEmailCount removes the count operator from an email SAN
+// matcher, like {count: 1}, which requires exactly count email SANs, e.g. so
+// that a certificate identifies a single user. It may be 0 to forbid email
+// SANs.
+func splitCertSANEmailCount(data parser.Value) (parser.Value, *int, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, nil, nil
+	}
+
+	v, ok := obj["count"]
+	if !ok {
+		return data, nil, nil
+	}
+
+	n, ok := v.(parser.Number)
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate SAN email count expects an integer (was %v)", v)
+	}
+	c, err := strconv.Atoi(string(n))
+	if err != nil || c < 0 {
+		return nil, nil, fmt.Errorf("certificate SAN email count expects a non-negative integer (was %s)", string(n))
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "count")
+	return obj, &c, nil
+}
+
+// splitCertSANEmailLocalPart removes the local_part operator from an email
+// SAN matcher.
+func splitCertSANEmailLocalPart(data parser.Value) (parser.Value, string, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, "", nil
+	}
+
+	v, ok := obj["local_part"]
+	if !ok {
+		return data, "", nil
+	}
+
+	localPart, ok := v.(parser.String)
+	if !ok {
+		return nil, "", fmt.Errorf("certificate SAN email local_part expects a string (was %v)", v)
+	} else if localPart == "" || strings.Contains(string(localPart), "@") {
+		return nil, "", fmt.Errorf("invalid certificate SAN email local_part: %q", string(localPart))
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "local_part")
+	return obj, string(localPart), nil
+}
+
+// A label of an email domain_glob pattern is a DNS label, in which * matches
+// any characters.
+var certSANEmailDomainGlobLabelRE = regexp.MustCompile(`^[a-z0-9*-]+$`)
+
+// splitCertSANEmailDomainGlob removes the domain_glob operator from an email
+// SAN matcher. The pattern is matched against the domain of the email with
+// . as the separator, so * matches within a single label and ** matches
+// across labels: *.contractors.corp matches a.contractors.corp, but not
+// contractors.corp or a.b.contractors.corp.
+func splitCertSANEmailDomainGlob(data parser.Value) (parser.Value, string, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, "", nil
+	}
+
+	v, ok := obj["domain_glob"]
+	if !ok {
+		return data, "", nil
+	}
+
+	s, ok := v.(parser.String)
+	if !ok {
+		return nil, "", fmt.Errorf("certificate SAN email domain_glob expects a string (was %v)", v)
+	}
+
+	// domains compare case-insensitively, and internationalized labels are
+	// converted to ASCII, since that's how email SANs are stored
+	labels := strings.Split(strings.ToLower(string(s)), ".")
+	for i, label := range labels {
+		if !isASCII(label) && !strings.Contains(label, "*") {
+			ascii, err := idna.Lookup.ToASCII(label)
+			if err == nil {
+				label = ascii
+			}
+		}
+		if !certSANEmailDomainGlobLabelRE.MatchString(label) {
+			return nil, "", fmt.Errorf("invalid certificate SAN email domain_glob: %q", string(s))
+		}
+		labels[i] = label
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "domain_glob")
+	return obj, strings.Join(labels, "."), nil
+}
+
+// splitCertSANEmailDomainIn removes the domain_in operator from an email SAN
+// matcher, returning the domains in lowercase ASCII form, since that's how
+// they're compared.
+func splitCertSANEmailDomainIn(data parser.Value) (parser.Value, []string, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, nil, nil
+	}
+
+	v, ok := obj["domain_in"]
+	if !ok {
+		return data, nil, nil
+	}
+
+	var pa parser.Array
+	switch v := v.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{v}
+	default:
+		return nil, nil, fmt.Errorf("certificate SAN email domain_in expects a string or array of strings (was %v)", v)
+	}
+	if len(pa) == 0 {
+		return nil, nil, errors.New("certificate SAN email domain_in must not be empty")
+	}
+
+	domains := make([]string, 0, len(pa))
+	for i, e := range pa {
+		s, ok := e.(parser.String)
+		if !ok {
+			return nil, nil, parser.ErrorAt(
+				fmt.Errorf("certificate SAN email domain_in expects a string (was %v)", e), "domain_in", strconv.Itoa(i))
+		} else if s == "" || strings.ContainsAny(string(s), "@:/ ") {
+			return nil, nil, parser.ErrorAt(
+				fmt.Errorf("invalid certificate SAN email domain_in: %q", string(s)), "domain_in", strconv.Itoa(i))
+		}
+		domain := strings.ToLower(string(s))
+		if !isASCII(domain) {
+			ascii, err := idna.Lookup.ToASCII(domain)
+			if err != nil {
+				return nil, nil, parser.ErrorAt(
+					fmt.Errorf("invalid certificate SAN email domain_in (%s): %w", string(s), err), "domain_in", strconv.Itoa(i))
+			}
+			domain = ascii
+		}
+		domains = append(domains, domain)
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "domain_in")
+	return obj, domains, nil
+}
+
+// normalizeCertSANEmailMatcher converts internationalized domain names in an
+// email SAN matcher to ASCII, since that's how email SANs are stored.
+func normalizeCertSANEmailMatcher(data parser.Value) (parser.Value, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return data, nil
+	}
+
+	normalized := make(parser.Object, len(obj))
+	for k, v := range obj {
+		if s, ok := v.(parser.String); ok && (k == "is" || k == "is_not" || k == "ends_with") {
+			email, err := normalizeEmailDomain(string(s))
+			if err != nil {
+				return nil, err
+			}
+			v = parser.String(email)
+		}
+		normalized[k] = v
+	}
+	return normalized, nil
+}
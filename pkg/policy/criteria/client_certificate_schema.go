@@ -0,0 +1,346 @@
+package criteria
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// CertificateMatcherSchema returns a JSON Schema describing the conditions
+// accepted by the client_certificate criterion.
+func CertificateMatcherSchema() map[string]interface{} {
+	stringOrStringArray := map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+	issuerFingerprintMatcher := map[string]interface{}{
+		"anyOf": []interface{}{
+			stringOrStringArray,
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"from_bundle": map[string]interface{}{"enum": []interface{}{true}},
+				},
+				"required":             []interface{}{"from_bundle"},
+				"additionalProperties": false,
+			},
+		},
+	}
+	emailMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"contains":               map[string]interface{}{"type": "string"},
+			"count":                  map[string]interface{}{"type": "integer", "minimum": 0},
+			"domain_glob":            map[string]interface{}{"type": "string"},
+			"domain_in":              stringOrStringArray,
+			"ends_with":              map[string]interface{}{"type": "string"},
+			"equals_session_sub":     map[string]interface{}{"type": "boolean"},
+			"in_session_claim":       map[string]interface{}{"type": "string", "minLength": 1},
+			"is":                     map[string]interface{}{"type": "string"},
+			"is_not":                 map[string]interface{}{"type": "string"},
+			"local_part":             map[string]interface{}{"type": "string"},
+			"optional":               map[string]interface{}{"type": "boolean"},
+			"same_domain_as_session": map[string]interface{}{"type": "boolean"},
+			"single_domain":          map[string]interface{}{"type": "boolean"},
+			"starts_with":            map[string]interface{}{"type": "string"},
+		},
+		"additionalProperties": false,
+	}
+	uriMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"contains":    map[string]interface{}{"type": "string"},
+			"count":       map[string]interface{}{"type": "integer", "minimum": 0},
+			"ends_with":   map[string]interface{}{"type": "string"},
+			"host":        map[string]interface{}{"type": "string", "minLength": 1},
+			"is":          map[string]interface{}{"type": "string"},
+			"is_not":      map[string]interface{}{"type": "string"},
+			"optional":    map[string]interface{}{"type": "boolean"},
+			"matches":     map[string]interface{}{"type": "string", "format": "regex"},
+			"scheme":      map[string]interface{}{"type": "string", "pattern": "^[A-Za-z][A-Za-z0-9+.-]*$"},
+			"starts_with": map[string]interface{}{"type": "string"},
+		},
+		"additionalProperties": false,
+	}
+	dnsMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"contains":            map[string]interface{}{"type": "string"},
+			"ends_with":           stringOrStringArray,
+			"equals_request_host": map[string]interface{}{"type": "boolean"},
+			"forbid_wildcard":     map[string]interface{}{"type": "boolean"},
+			"in_reverse_zone":     map[string]interface{}{"type": "string"},
+			"is":                  map[string]interface{}{"type": "string"},
+			"is_not":              map[string]interface{}{"type": "string"},
+			"optional":            map[string]interface{}{"type": "boolean"},
+			"require_fqdn":        map[string]interface{}{"type": "boolean"},
+			"starts_with":         map[string]interface{}{"type": "string"},
+		},
+		"additionalProperties": false,
+	}
+	ipMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"in":       stringOrStringArray,
+			"optional": map[string]interface{}{"type": "boolean"},
+		},
+		"required":             []interface{}{"in"},
+		"additionalProperties": false,
+	}
+	keyUsageNames := make([]string, 0, len(certKeyUsages))
+	for name := range certKeyUsages {
+		keyUsageNames = append(keyUsageNames, name)
+	}
+	slices.Sort(keyUsageNames)
+	keyUsageEnum := make([]interface{}, len(keyUsageNames))
+	for i, name := range keyUsageNames {
+		keyUsageEnum[i] = name
+	}
+	signatureSchemeNames := make([]string, 0, len(certSignatureSchemes))
+	for name := range certSignatureSchemes {
+		signatureSchemeNames = append(signatureSchemeNames, name)
+	}
+	slices.Sort(signatureSchemeNames)
+	signatureSchemeEnum := make([]interface{}, len(signatureSchemeNames))
+	for i, name := range signatureSchemeNames {
+		signatureSchemeEnum[i] = name
+	}
+	signatureSchemeMatcher := map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"enum": signatureSchemeEnum},
+			map[string]interface{}{
+				"type":     "array",
+				"items":    map[string]interface{}{"enum": signatureSchemeEnum},
+				"minItems": 1,
+			},
+		},
+	}
+	keyUsageList := map[string]interface{}{
+		"type":     "array",
+		"items":    map[string]interface{}{"enum": keyUsageEnum},
+		"minItems": 1,
+	}
+	keyUsageMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"all_of": keyUsageList,
+			"any_of": keyUsageList,
+		},
+		"additionalProperties": false,
+	}
+	extKeyUsageMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"exactly": map[string]interface{}{
+				"type":     "array",
+				"items":    map[string]interface{}{"type": "string"},
+				"minItems": 1,
+			},
+		},
+		"additionalProperties": false,
+	}
+	sanMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"any_of": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"items": caseInsensitiveProperties(map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"dns":   dnsMatcher,
+						"email": emailMatcher,
+						"uri":   uriMatcher,
+					},
+					"additionalProperties": false,
+					"minProperties":        1,
+				}),
+			},
+		},
+		"additionalProperties": false,
+	}
+	subjectMatcher := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"cn_email_domain": map[string]interface{}{"type": "string", "minLength": 1},
+			"ou":              stringOrStringArray,
+			"ou_contains":     map[string]interface{}{"type": "string"},
+			"serial_number":   map[string]interface{}{"type": "string", "minLength": 1},
+		},
+		"additionalProperties": false,
+	}
+	// the branches of a matcher, which may also be negated
+	branches := []interface{}{
+		map[string]interface{}{"$ref": "#/definitions/certificate_conditions"},
+		map[string]interface{}{
+			"type":     "array",
+			"items":    map[string]interface{}{"$ref": "#/definitions/certificate_conditions"},
+			"minItems": 1,
+		},
+	}
+	return map[string]interface{}{
+		"$ref": "#/definitions/certificate_matcher",
+		"definitions": map[string]interface{}{
+			"certificate_matcher": map[string]interface{}{
+				"anyOf": append(slices.Clone(branches), caseInsensitiveProperties(map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"not": map[string]interface{}{"anyOf": branches},
+					},
+					"minProperties":        1,
+					"maxProperties":        1,
+					"additionalProperties": false,
+				})),
+			},
+			"certificate_conditions": caseInsensitiveProperties(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"fingerprint": map[string]interface{}{
+						"anyOf": []interface{}{
+							stringOrStringArray,
+							map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"prefix": map[string]interface{}{"type": "string", "pattern": "^(?:[0-9A-Fa-f]{2}){1,32}$"},
+								},
+								"required":             []interface{}{"prefix"},
+								"additionalProperties": false,
+							},
+							map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"from_data": map[string]interface{}{
+										"type":    "string",
+										"pattern": "^[A-Za-z_][A-Za-z0-9_]*(?:\\.[A-Za-z_][A-Za-z0-9_]*)*$",
+									},
+								},
+								"required":             []interface{}{"from_data"},
+								"additionalProperties": false,
+							},
+							map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"file": map[string]interface{}{"type": "string", "minLength": 1},
+								},
+								"required":             []interface{}{"file"},
+								"additionalProperties": false,
+							},
+						},
+					},
+					"pem_fingerprint":    stringOrStringArray,
+					"spki_hash":          stringOrStringArray,
+					"issuer_fingerprint": issuerFingerprintMatcher,
+					"root_fingerprint":   stringOrStringArray,
+					"san_email":          emailMatcher,
+					"san_dns":            dnsMatcher,
+					"san_uri":            uriMatcher,
+					"san_ip":             ipMatcher,
+					"san":                sanMatcher,
+					"san_denylist":       stringOrStringArray,
+					"subject":            subjectMatcher,
+					"subject_dn":         map[string]interface{}{"type": "string", "minLength": 1},
+					"aia_ocsp_host":      stringOrStringArray,
+					"key_usage":          keyUsageMatcher,
+					"extended_key_usage": extKeyUsageMatcher,
+					"issued_after":       map[string]interface{}{"type": "string", "format": "date-time"},
+					"self_signed":        map[string]interface{}{"type": "boolean"},
+					"require_crl_dp":     map[string]interface{}{"type": "boolean"},
+					"require_sct":        map[string]interface{}{"type": "boolean"},
+					"max_total_san":      map[string]interface{}{"type": "integer", "minimum": 0},
+					"ip_only":            map[string]interface{}{"type": "boolean"},
+					"expires_within": map[string]interface{}{
+						"anyOf": []interface{}{
+							map[string]interface{}{"type": "string"},
+							map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"not": map[string]interface{}{"type": "string"},
+								},
+								"required":             []interface{}{"not"},
+								"additionalProperties": false,
+							},
+						},
+					},
+					"serial_number": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"mod": map[string]interface{}{
+								"type":     "array",
+								"items":    map[string]interface{}{"type": "integer", "minimum": 0},
+								"minItems": 2,
+								"maxItems": 2,
+							},
+						},
+						"required":             []interface{}{"mod"},
+						"additionalProperties": false,
+					},
+					"san_raw": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"oid":          map[string]interface{}{"type": "string"},
+							"value_base64": map[string]interface{}{"type": "string"},
+						},
+						"required":             []interface{}{"oid", "value_base64"},
+						"additionalProperties": false,
+					},
+					"issued_within":       map[string]interface{}{"type": "string"},
+					"max_validity":        map[string]interface{}{"type": "string"},
+					"issuer_serial":       map[string]interface{}{"type": "string", "minLength": 1},
+					"signature_scheme":    signatureSchemeMatcher,
+					"aia_ca_issuers_host": stringOrStringArray,
+					"warn": map[string]interface{}{
+						"$ref":          "#/definitions/certificate_conditions",
+						"minProperties": 1,
+					},
+					"reason_fields": map[string]interface{}{
+						"anyOf": []interface{}{
+							map[string]interface{}{"enum": []interface{}{"fingerprint", "subject_cn"}},
+							map[string]interface{}{
+								"type":  "array",
+								"items": map[string]interface{}{"enum": []interface{}{"fingerprint", "subject_cn"}},
+							},
+						},
+					},
+				},
+				"additionalProperties": false,
+			}),
+		},
+	}
+}
+
+// caseInsensitiveProperties adds a patternProperties entry to an object
+// schema for each of its properties, matching the property name in any case.
+// GenerateRule lowercases condition keys, so the schema must accept them in
+// any case too. JSON Schema patterns have no case-insensitive flag, so each
+// letter is spelled out as a character class.
+func caseInsensitiveProperties(schema map[string]interface{}) map[string]interface{} {
+	properties := schema["properties"].(map[string]interface{})
+	patterns := make(map[string]interface{}, len(properties))
+	for k, v := range properties {
+		patterns[caseInsensitivePattern(k)] = v
+	}
+	schema["patternProperties"] = patterns
+	return schema
+}
+
+func caseInsensitivePattern(name string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			b.WriteByte('[')
+			b.WriteRune(unicode.ToUpper(r))
+			b.WriteRune(unicode.ToLower(r))
+			b.WriteByte(']')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
@@ -0,0 +1,432 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// addCertSubjectCondition adds conditions on the certificate's subject.
+//
+// A subject may have multiple organizational units, e.g. OU=eng, OU=backend.
+// The ou operator matches the exact list of OUs, in order, while ou_contains
+// matches if any of the OUs is equal to the value.
+//
+// Some CAs put an email address in the common name, and the cn_email_domain
+// operator matches the domain of such a CN, case-insensitively. A CN which
+// isn't an email address never matches.
+func addCertSubjectCondition(body *ast.Body, data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for certificate subject matcher, got: %T", data)
+	}
+
+	for k, v := range obj {
+		switch k {
+		case "ou":
+			ous, err := parseCertSubjectOUs(v)
+			if err != nil {
+				return err
+			}
+			*body = append(*body, ast.Equal.Expr(
+				ast.MustParseTerm(`[ou | ou := cert.Subject.OrganizationalUnit[_]]`),
+				ast.NewTerm(ast.MustInterfaceToValue(ous))))
+		case "ou_contains":
+			ou, err := parseCertSubjectOU(v)
+			if err != nil {
+				return err
+			}
+			*body = append(*body, ast.Equal.Expr(
+				ast.VarTerm("cert.Subject.OrganizationalUnit[_]"), ast.StringTerm(ou)))
+		case "serial_number":
+			sn, err := parseCertSubjectSerialNumber(v)
+			if err != nil {
+				return err
+			}
+			*body = append(*body, ast.Equal.Expr(
+				ast.VarTerm("cert.Subject.SerialNumber"), ast.StringTerm(sn)))
+		case "cn_email_domain":
+			domain, err := parseCertSubjectCNEmailDomain(v)
+			if err != nil {
+				return err
+			}
+			*body = append(*body, certSubjectCNEmailBody...)
+			*body = append(*body, ast.Equal.Expr(
+				ast.VarTerm("cn_email_domain"), ast.StringTerm(domain)))
+		default:
+			return fmt.Errorf("unsupported certificate subject condition: %s", k)
+		}
+	}
+	return nil
+}
+
+func validateCertSubjectMatcher(data parser.Value) error {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for certificate subject matcher, got: %T", data)
+	}
+
+	for k, v := range obj {
+		var err error
+		switch k {
+		case "ou":
+			_, err = parseCertSubjectOUs(v)
+		case "ou_contains":
+			_, err = parseCertSubjectOU(v)
+		case "serial_number":
+			_, err = parseCertSubjectSerialNumber(v)
+		case "cn_email_domain":
+			_, err = parseCertSubjectCNEmailDomain(v)
+		default:
+			err = fmt.Errorf("unsupported certificate subject condition: %s", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A common name is an email address if it has a single @ between a local part
+// and a domain, neither of which contain whitespace.
+var certSubjectCNEmailBody = ast.MustParseBody(`
+	regex.match("^[^@\\s]+@[^@\\s]+$", cert.Subject.CommonName)
+	cn_email_domain := lower(regex.replace(cert.Subject.CommonName, "^.*@", ""))
+`)
+
+// parseCertSubjectCNEmailDomain returns the lowercase ASCII form of the domain
+// of a subject cn_email_domain condition.
+func parseCertSubjectCNEmailDomain(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate subject cn_email_domain must be a string (was %v)", data)
+	} else if s == "" {
+		return "", errors.New("certificate subject cn_email_domain must not be empty")
+	} else if strings.ContainsAny(string(s), "@:/ ") {
+		return "", fmt.Errorf("certificate subject cn_email_domain must be a domain name (was %s)", string(s))
+	}
+
+	email, err := normalizeEmailDomain("@" + string(s))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(email[1:]), nil
+}
+
+// parseCertSubjectSerialNumber returns the value of a subject serial_number
+// condition. This is the serialNumber attribute of the subject, often a device
+// ID, rather than the serial number of the certificate itself.
+func parseCertSubjectSerialNumber(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate subject serial_number must be a string (was %v); "+
+			"it matches the subject serialNumber attribute, not the certificate serial number", data)
+	} else if s == "" {
+		return "", errors.New("certificate subject serial_number must not be empty; " +
+			"it matches the subject serialNumber attribute, not the certificate serial number")
+	}
+	return string(s), nil
+}
+
+func parseCertSubjectOU(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate subject OU must be a string (was %v)", data)
+	} else if s == "" {
+		return "", errors.New("certificate subject OU must not be empty")
+	}
+	return string(s), nil
+}
+
+func parseCertSubjectOUs(data parser.Value) ([]string, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, errors.New("certificate subject ou expects a string or array of strings")
+	}
+
+	ous := make([]string, 0, len(pa))
+	for _, v := range pa {
+		ou, err := parseCertSubjectOU(v)
+		if err != nil {
+			return nil, err
+		}
+		ous = append(ous, ou)
+	}
+	return ous, nil
+}
+
+// The subject DN is compared as the sorted list of its attributes, each
+// rendered as OID=value, so that the order of the attributes, and how they're
+// grouped into RDNs, doesn't matter.
+var certSubjectDNBody = ast.MustParseBody(`
+	subject_dn := sort([concat("=", [
+		concat(".", [format_int(subject_oid_arc, 10) | subject_oid_arc := subject_attr.Type[_]]),
+		subject_attr.Value,
+	]) | subject_attr := cert.Subject.Names[_]])
+`)
+
+// addCertSubjectDNCondition adds a condition requiring that the certificate's
+// subject is the given distinguished name.
+func addCertSubjectDNCondition(body *ast.Body, data parser.Value) error {
+	attrs, err := parseCertSubjectDN(data)
+	if err != nil {
+		return err
+	}
+
+	*body = append(*body, certSubjectDNBody...)
+	*body = append(*body, ast.Equal.Expr(
+		ast.VarTerm("subject_dn"), ast.NewTerm(ast.MustInterfaceToValue(attrs))))
+	return nil
+}
+
+// certSubjectDNAttributeTypes are the OIDs of the attribute type names which
+// may be used in a subject DN. Names compare case-insensitively.
+var certSubjectDNAttributeTypes = map[string]string{
+	"cn":           "2.5.4.3",
+	"sn":           "2.5.4.4",
+	"surname":      "2.5.4.4",
+	"serialnumber": "2.5.4.5",
+	"c":            "2.5.4.6",
+	"l":            "2.5.4.7",
+	"st":           "2.5.4.8",
+	"street":       "2.5.4.9",
+	"o":            "2.5.4.10",
+	"ou":           "2.5.4.11",
+	"title":        "2.5.4.12",
+	"postalcode":   "2.5.4.17",
+	"gn":           "2.5.4.42",
+	"givenname":    "2.5.4.42",
+	"uid":          "0.9.2342.19200300.100.1.1",
+	"dc":           "0.9.2342.19200300.100.1.25",
+	"emailaddress": "1.2.840.113549.1.9.1",
+	"email":        "1.2.840.113549.1.9.1",
+	"e":            "1.2.840.113549.1.9.1",
+}
+
+var certSubjectDNOIDRE = regexp.MustCompile(`^(?i:oid\.)?([0-9]+(?:\.[0-9]+)+)$`)
+
+// parseCertSubjectDN returns the attributes of a subject DN, each as
+// OID=value, sorted. The DN may be in the RFC 2253 format, like
+// "CN=bob,O=corp", or any of the formats printed by openssl x509 -subject,
+// like "subject=CN = bob, O = corp" or "/CN=bob/O=corp". The order of the
+// attributes and the spacing around them don't matter, but values are
+// case-sensitive.
+func parseCertSubjectDN(data parser.Value) ([]string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return nil, fmt.Errorf("certificate subject_dn expects a string (was %v)", data)
+	}
+
+	dn := strings.TrimSpace(string(s))
+	if len(dn) > len("subject=") && strings.EqualFold(dn[:len("subject=")], "subject=") {
+		dn = strings.TrimSpace(dn[len("subject="):])
+	}
+	separators := ",;+"
+	if strings.HasPrefix(dn, "/") {
+		separators = "/+"
+		dn = dn[1:]
+	}
+
+	rawAttrs, err := splitCertSubjectDN(dn, separators)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate subject_dn (%s): %w", string(s), err)
+	}
+
+	attrs := make([]string, 0, len(rawAttrs))
+	for _, attr := range rawAttrs {
+		oid, ok := certSubjectDNAttributeTypes[strings.ToLower(attr[0])]
+		if m := certSubjectDNOIDRE.FindStringSubmatch(attr[0]); m != nil {
+			oid, ok = m[1], true
+		}
+		if !ok {
+			return nil, fmt.Errorf("unsupported certificate subject_dn attribute type: %s", attr[0])
+		}
+		attrs = append(attrs, oid+"="+attr[1])
+	}
+	slices.Sort(attrs)
+	return attrs, nil
+}
+
+// splitCertSubjectDN splits a DN into its attributes' types and values,
+// unescaping the values. Escaped and quoted separators are part of a value.
+func splitCertSubjectDN(dn string, separators string) ([][2]string, error) {
+	var attrs [][2]string
+	var typ, value strings.Builder
+	inValue, inQuotes := false, false
+
+	endAttr := func() error {
+		t, v := strings.TrimSpace(typ.String()), strings.TrimSpace(value.String())
+		if !inValue || t == "" {
+			return fmt.Errorf("expected type=value, got: %s", typ.String())
+		} else if strings.HasPrefix(v, "#") {
+			return errors.New("hex-encoded values are not supported")
+		}
+		attrs = append(attrs, [2]string{t, v})
+		typ.Reset()
+		value.Reset()
+		inValue = false
+		return nil
+	}
+
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(dn) {
+				return nil, errors.New("trailing escape")
+			}
+			if i+2 < len(dn) && isHexDigit(dn[i+1]) && isHexDigit(dn[i+2]) {
+				b, _ := strconv.ParseUint(dn[i+1:i+3], 16, 8)
+				c = byte(b)
+				i += 2
+			} else {
+				c = dn[i+1]
+				i++
+			}
+		case c == '"' && inValue:
+			inQuotes = !inQuotes
+			continue
+		case inQuotes:
+		case c == '=' && !inValue:
+			inValue = true
+			continue
+		case strings.IndexByte(separators, c) >= 0:
+			if err := endAttr(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if inValue {
+			value.WriteByte(c)
+		} else {
+			typ.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unterminated quote")
+	}
+	if err := endAttr(); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// Serial numbers may be up to 20 bytes, but Rego's arithmetic on integers
+// beyond 64 bits isn't exact, so the serial number modulo N is computed from
+// its decimal digits: each digit is multiplied by the weight of its position,
+// 10^i mod N, and the sum taken modulo N. The sum of at most
+// maxCertSerialNumberDigits small products is exact.
+var certSerialNumberModBody = ast.MustParseBody(`
+	serial_digits := json.marshal(cert.SerialNumber)
+	regex.match("^[0-9]+$", serial_digits)
+	serial_len := count(serial_digits)
+	serial_terms := [serial_term |
+		i := numbers.range(0, serial_len - 1)[_]
+		serial_term := to_number(substring(serial_digits, i, 1)) * serial_mod_weights[(serial_len - 1) - i]
+	]
+	serial_mod := sum(serial_terms) % serial_mod_n
+	serial_len <= count(serial_mod_weights)
+`)
+
+const (
+	// The most digits of a serial number, which is at most 20 bytes and so
+	// at most 49 digits, with room to spare for non-conforming CAs.
+	maxCertSerialNumberDigits = 64
+	// The largest serial_number mod modulus, so that the sum of the weighted
+	// digits is exact.
+	maxCertSerialNumberModulus = 1 << 32
+)
+
+// addCertSerialNumberCondition adds a condition on the certificate's serial
+// number, which unlike the subject serial_number is assigned by the CA. The
+// mod operator, like {mod: [2, 0]}, requires that the serial number modulo N
+// is R, e.g. for a CA which encodes the environment in the parity of its
+// serial numbers.
+func addCertSerialNumberCondition(body *ast.Body, data parser.Value) error {
+	n, rem, err := parseCertSerialNumberMod(data)
+	if err != nil {
+		return err
+	}
+
+	weights := make([]*ast.Term, maxCertSerialNumberDigits)
+	w := int64(1) % n
+	for i := range weights {
+		weights[i] = ast.IntNumberTerm(int(w))
+		w = w * 10 % n
+	}
+
+	*body = append(*body,
+		ast.Assign.Expr(ast.VarTerm("serial_mod_n"), ast.IntNumberTerm(int(n))),
+		ast.Assign.Expr(ast.VarTerm("serial_mod_weights"), ast.ArrayTerm(weights...)))
+	*body = append(*body, certSerialNumberModBody...)
+	*body = append(*body, ast.Equal.Expr(ast.VarTerm("serial_mod"), ast.IntNumberTerm(int(rem))))
+	return nil
+}
+
+// parseCertSerialNumberMod returns the modulus and remainder of a
+// serial_number mod operator. The modulus must be positive, up to 2^32, and
+// the remainder less than it.
+func parseCertSerialNumberMod(data parser.Value) (n, rem int64, err error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return 0, 0, fmt.Errorf("expected object for certificate serial_number matcher, got: %T", data)
+	}
+	for k := range obj {
+		if k != "mod" {
+			return 0, 0, fmt.Errorf("unsupported certificate serial_number operator: %s", k)
+		}
+	}
+
+	v, ok := obj["mod"]
+	if !ok {
+		return 0, 0, errors.New("certificate serial_number expects an object with mod")
+	}
+	pa, ok := v.(parser.Array)
+	if !ok || len(pa) != 2 {
+		return 0, 0, parser.ErrorAt(
+			fmt.Errorf("certificate serial_number mod expects an array of a modulus and a remainder (was %v)", v), "mod")
+	}
+	for i, e := range pa {
+		num, ok := e.(parser.Number)
+		var x int64
+		if ok {
+			x, err = strconv.ParseInt(string(num), 10, 64)
+		}
+		if !ok || err != nil {
+			return 0, 0, parser.ErrorAt(
+				fmt.Errorf("certificate serial_number mod expects integers (was %v)", e), "mod", strconv.Itoa(i))
+		}
+		if i == 0 {
+			n = x
+		} else {
+			rem = x
+		}
+	}
+	if n <= 0 || n > maxCertSerialNumberModulus {
+		return 0, 0, parser.ErrorAt(
+			fmt.Errorf("certificate serial_number mod modulus must be between 1 and %d (was %d)",
+				maxCertSerialNumberModulus, n), "mod", "0")
+	} else if rem < 0 || rem >= n {
+		return 0, 0, parser.ErrorAt(
+			fmt.Errorf("certificate serial_number mod remainder must be at least 0 and less than %d (was %d)", n, rem),
+			"mod", "1")
+	}
+	return n, rem, nil
+}
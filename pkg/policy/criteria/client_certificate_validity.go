@@ -0,0 +1,154 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// addCertIssuedAfterCondition adds a condition requiring that the certificate
+// is valid from the cutoff time or later, e.g. to reject certificates issued
+// before a CA key rotation. A not-before time too far in the past to be
+// represented in nanoseconds fails to parse, and so doesn't match.
+func addCertIssuedAfterCondition(body *ast.Body, data parser.Value) error {
+	cutoff, err := parseCertIssuedAfter(data)
+	if err != nil {
+		return err
+	}
+
+	*body = append(*body, ast.GreaterThanEq.Expr(
+		ast.ParseRFC3339Nanos.Call(ast.VarTerm("cert.NotBefore")),
+		ast.IntNumberTerm(int(cutoff.UnixNano()))))
+	return nil
+}
+
+// addCertExpiresWithinCondition adds a condition requiring that the
+// certificate expires within a window from now, e.g. for a route which steps
+// up authentication for certificates which are about to expire. An expired
+// certificate is within any window.
+//
+// The not form requires that the certificate doesn't expire within the
+// window, so that as a soft condition it warns about certificates which do:
+//
+//	warn:
+//	  expires_within: {not: 30d}
+func addCertExpiresWithinCondition(body *ast.Body, data parser.Value) error {
+	window, negated, err := parseCertExpiresWithinCondition(data)
+	if err != nil {
+		return err
+	}
+
+	op := ast.LessThan
+	if negated {
+		op = ast.GreaterThanEq
+	}
+	*body = append(*body, op.Expr(
+		ast.Minus.Call(
+			ast.ParseRFC3339Nanos.Call(ast.VarTerm("cert.NotAfter")),
+			ast.NowNanos.Call()),
+		durationTerm(window)))
+	return nil
+}
+
+// parseCertExpiresWithinCondition parses an expires_within condition, which
+// is either a window or an object with the not operator and a window.
+func parseCertExpiresWithinCondition(data parser.Value) (window time.Duration, negated bool, err error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		window, err = parseCertWindow("expires_within", data)
+		return window, false, err
+	}
+
+	for k := range obj {
+		if k != "not" {
+			return 0, false, fmt.Errorf("unsupported certificate expires_within operator: %s", k)
+		}
+	}
+	v, ok := obj["not"]
+	if !ok {
+		return 0, false, errors.New("certificate expires_within expects a duration or an object with not")
+	}
+	window, err = parseCertWindow("expires_within", v)
+	return window, true, parser.ErrorAt(err, "not")
+}
+
+// addCertIssuedWithinCondition adds a condition requiring that the
+// certificate was issued within a window before now, e.g. to force clients to
+// pick up recently issued certificates. A certificate which isn't valid yet is
+// within any window.
+func addCertIssuedWithinCondition(body *ast.Body, data parser.Value) error {
+	window, err := parseCertWindow("issued_within", data)
+	if err != nil {
+		return err
+	}
+
+	*body = append(*body, ast.LessThanEq.Expr(
+		ast.Minus.Call(
+			ast.NowNanos.Call(),
+			ast.ParseRFC3339Nanos.Call(ast.VarTerm("cert.NotBefore"))),
+		durationTerm(window)))
+	return nil
+}
+
+// addCertMaxValidityCondition adds a condition requiring that the certificate's
+// total validity period, from NotBefore to NotAfter, is at most a limit, e.g.
+// to reject mis-issued long-lived certificates.
+func addCertMaxValidityCondition(body *ast.Body, data parser.Value) error {
+	limit, err := parseCertWindow("max_validity", data)
+	if err != nil {
+		return err
+	}
+
+	*body = append(*body, ast.LessThanEq.Expr(
+		ast.Minus.Call(
+			ast.ParseRFC3339Nanos.Call(ast.VarTerm("cert.NotAfter")),
+			ast.ParseRFC3339Nanos.Call(ast.VarTerm("cert.NotBefore"))),
+		durationTerm(limit)))
+	return nil
+}
+
+// The longest window of an expires_within, issued_within or max_validity
+// condition in days, so that it can be represented in nanoseconds.
+const maxCertWindowDays = math.MaxInt64 / int64(24*time.Hour)
+
+// parseCertWindow parses the window of an expires_within, issued_within or
+// max_validity condition, which is either a whole number of days, like "30d", or a
+// duration, like "72h".
+func parseCertWindow(condition string, data parser.Value) (time.Duration, error) {
+	s, ok := data.(parser.String)
+	if !ok || !strings.HasSuffix(string(s), "d") {
+		return parseDuration("certificate "+condition, data)
+	}
+
+	days, err := strconv.ParseInt(strings.TrimSuffix(string(s), "d"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid certificate %s duration (%s): expected a whole number of days", condition, string(s))
+	} else if days <= 0 || days > maxCertWindowDays {
+		return 0, fmt.Errorf("certificate %s duration is out of range (was %s)", condition, string(s))
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// parseCertIssuedAfter parses the RFC 3339 cutoff time of an issued_after
+// condition.
+func parseCertIssuedAfter(data parser.Value) (time.Time, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return time.Time{}, fmt.Errorf("certificate issued_after expects an RFC 3339 timestamp (was %v)", data)
+	}
+
+	t, err := time.Parse(time.RFC3339, string(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid certificate issued_after timestamp (%s): %w", string(s), err)
+	} else if !time.Unix(0, t.UnixNano()).Equal(t) {
+		return time.Time{}, fmt.Errorf("certificate issued_after timestamp is out of range (was %s)", string(s))
+	}
+	return t, nil
+}
@@ -0,0 +1,137 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The reverse DNS zones of IPv4 and IPv6 addresses, and the labels of the
+// addresses within them: decimal octets and hex nibbles respectively.
+var certSANDNSReverseZones = []struct {
+	suffix    string
+	maxLabels int
+	labelRE   *regexp.Regexp
+}{
+	{"in-addr.arpa", 4, regexp.MustCompile(`^(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$`)},
+	{"ip6.arpa", 32, regexp.MustCompile(`^[0-9a-f]$`)},
+}
+
+// parseCertSANDNSReverseZone returns the lowercase reverse DNS zone of a DNS
+// SAN in_reverse_zone operator, without any trailing dot.
+func parseCertSANDNSReverseZone(data parser.Value) (string, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("certificate SAN DNS in_reverse_zone expects a string (was %v)", data)
+	}
+
+	zone := strings.TrimSuffix(strings.ToLower(string(s)), ".")
+	for _, rz := range certSANDNSReverseZones {
+		if zone != rz.suffix && !strings.HasSuffix(zone, "."+rz.suffix) {
+			continue
+		}
+
+		labels := strings.Split(strings.TrimSuffix(zone, rz.suffix), ".")
+		labels = labels[:len(labels)-1]
+		valid := len(labels) <= rz.maxLabels
+		for _, label := range labels {
+			valid = valid && rz.labelRE.MatchString(label)
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid certificate SAN DNS in_reverse_zone: %q", string(s))
+		}
+		return zone, nil
+	}
+	return "", fmt.Errorf("certificate SAN DNS in_reverse_zone expects an in-addr.arpa or ip6.arpa zone (was %q)",
+		string(s))
+}
+
+// addCertSANIPCondition adds a condition over the IP address SANs. The in
+// operator references network zones supplied to the Generator by name, and
+// requires an IP SAN within one of the zones' CIDRs.
+func addCertSANIPCondition(
+	b *certMatcherBranch, deny *[]ast.Body, data parser.Value, lookupZone func(name string) ([]string, bool),
+) error {
+	names, obj, err := parseCertSANIPMatcher(data)
+	if err != nil {
+		return err
+	}
+
+	cidrs := ast.NewArray()
+	for _, name := range names {
+		zone, ok := lookupZone(name)
+		if !ok {
+			return fmt.Errorf("certificate SAN IP network zone is not set: %s", name)
+		}
+		if len(zone) == 0 {
+			// an empty zone would never match, which is surely a mistake
+			return fmt.Errorf("certificate SAN IP network zone has no CIDRs: %s", name)
+		}
+		for _, cidr := range zone {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid CIDR in network zone %s: %w", name, err)
+			}
+			cidrs = cidrs.Append(ast.StringTerm(cidr))
+		}
+	}
+
+	var conditions ast.Body
+	if cidrs.Len() == 1 {
+		conditions = append(conditions,
+			ast.NetCIDRContains.Expr(cidrs.Elem(0), certSANIP.value()))
+	} else {
+		conditions = append(conditions,
+			ast.Assign.Expr(ast.VarTerm("ip_cidr"), ast.RefTerm(ast.NewTerm(cidrs), ast.VarTerm("_"))),
+			ast.NetCIDRContains.Expr(ast.VarTerm("ip_cidr"), certSANIP.value()))
+	}
+
+	return addCertSANCondition(b, deny, certSANIP, conditions, obj)
+}
+
+// parseCertSANIPMatcher returns the network zone names of an IP SAN matcher,
+// along with the rest of the matcher.
+func parseCertSANIPMatcher(data parser.Value) ([]string, parser.Object, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for certificate SAN IP matcher, got: %T", data)
+	}
+	for k := range obj {
+		if k != "in" && k != "optional" {
+			return nil, nil, fmt.Errorf("unsupported certificate SAN IP matcher condition: %s", k)
+		}
+	}
+
+	var pa parser.Array
+	switch v := obj["in"].(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{v}
+	default:
+		return nil, nil, errors.New("certificate SAN IP in expects a network zone name or array of names")
+	}
+	if len(pa) == 0 {
+		return nil, nil, errors.New("certificate SAN IP in must not be empty")
+	}
+
+	names := make([]string, 0, len(pa))
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, nil, fmt.Errorf("certificate SAN IP network zone name must be a string (was %v)", v)
+		} else if s == "" {
+			return nil, nil, errors.New("certificate SAN IP network zone name must not be empty")
+		}
+		names = append(names, string(s))
+	}
+
+	obj = obj.Clone().(parser.Object)
+	delete(obj, "in")
+	return names, obj, nil
+}
@@ -21,27 +21,70 @@ type (
 	CriterionDataType = generator.CriterionDataType
 )
 
-var allCriteria struct {
-	sync.Mutex
-	a []CriterionConstructor
+// A CriterionWithReasons is a Criterion which can list all the reasons it
+// may return, e.g. so that audit tooling can know the full set of reasons up
+// front.
+type CriterionWithReasons interface {
+	Criterion
+	Reasons() Reasons
 }
 
+// A Registry is a collection of criterion constructors.
+//
+// The package-level All and Register functions use a global Registry. Tests
+// which need to inject their own criteria should use an isolated Registry
+// instead, so that the global state is left untouched.
+type Registry struct {
+	mu sync.Mutex
+	a  []CriterionConstructor
+}
+
+// NewRegistry creates a new Registry containing the given criterion
+// constructors.
+func NewRegistry(criterionConstructors ...CriterionConstructor) *Registry {
+	r := new(Registry)
+	r.a = append(r.a, criterionConstructors...)
+	return r
+}
+
+// All returns all the criterion constructors in the registry.
+func (r *Registry) All() []CriterionConstructor {
+	r.mu.Lock()
+	a := r.a
+	r.mu.Unlock()
+	return a
+}
+
+// Register registers a criterion in the registry.
+func (r *Registry) Register(criterionConstructor CriterionConstructor) {
+	r.mu.Lock()
+	a := make([]CriterionConstructor, 0, len(r.a)+1)
+	a = append(a, r.a...)
+	a = append(a, criterionConstructor)
+	r.a = a
+	r.mu.Unlock()
+}
+
+// GeneratorOptions returns generator options for all the criterion
+// constructors in the registry.
+func (r *Registry) GeneratorOptions() []generator.Option {
+	var options []generator.Option
+	for _, criterionConstructor := range r.All() {
+		options = append(options, generator.WithCriterion(criterionConstructor))
+	}
+	return options
+}
+
+var globalRegistry = NewRegistry()
+
 // All returns all the known criterion constructors.
 func All() []CriterionConstructor {
-	allCriteria.Lock()
-	a := allCriteria.a
-	allCriteria.Unlock()
-	return a
+	return globalRegistry.All()
 }
 
 // Register registers a criterion.
 func Register(criterionConstructor CriterionConstructor) {
-	allCriteria.Lock()
-	a := make([]CriterionConstructor, 0, len(allCriteria.a)+1)
-	a = append(a, allCriteria.a...)
-	a = append(a, criterionConstructor)
-	allCriteria.a = a
-	allCriteria.Unlock()
+	globalRegistry.Register(criterionConstructor)
 }
 
 const (
@@ -182,6 +225,44 @@ func NewCriterionTerm(value bool, reasons ...Reason) *ast.Term {
 	)
 }
 
+// A ReasonField is a named value interpolated into a templated reason.
+type ReasonField struct {
+	Name  string
+	Value *ast.Term
+}
+
+// maxReasonFieldLength is the maximum length of an interpolated field value.
+const maxReasonFieldLength = 64
+
+// NewTemplatedReasonExpr creates a rego expression which assigns a reason with
+// interpolated field values to a variable:
+//
+//	reason := "reason:name1=value1,name2=value2"
+//
+// Field values are restricted to a safe set of characters and truncated, so the
+// length of the reason is bounded.
+func NewTemplatedReasonExpr(v *ast.Term, reason Reason, fields ...ReasonField) *ast.Expr {
+	terms := []*ast.Term{ast.StringTerm(string(reason))}
+	for i, f := range fields {
+		sep := ","
+		if i == 0 {
+			sep = ":"
+		}
+		terms = append(terms,
+			ast.StringTerm(sep+f.Name+"="),
+			ast.Substring.Call(
+				ast.RegexReplace.Call(
+					f.Value,
+					ast.StringTerm(`[^A-Za-z0-9 .:@_-]`),
+					ast.StringTerm("_"),
+				),
+				ast.IntNumberTerm(0),
+				ast.IntNumberTerm(maxReasonFieldLength),
+			))
+	}
+	return ast.Assign.Expr(v, ast.Concat.Call(ast.StringTerm(""), ast.ArrayTerm(terms...)))
+}
+
 // NewCriterionTermWithAdditionalData creates a new rego term for a criterion with additional data:
 //
 //	[true, {"reason"}, {"key": "value"}]
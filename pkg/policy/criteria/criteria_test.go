@@ -13,6 +13,8 @@ import (
 	"github.com/open-policy-agent/opa/format"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
@@ -34,27 +36,45 @@ type (
 		HTTP                     InputHTTP    `json:"http"`
 		Session                  InputSession `json:"session"`
 		IsValidClientCertificate bool         `json:"is_valid_client_certificate"`
+		Route                    *InputRoute  `json:"route,omitempty"`
+	}
+	InputRoute struct {
+		ID       string                 `json:"id,omitempty"`
+		Internal bool                   `json:"internal,omitempty"`
+		Flags    map[string]interface{} `json:"flags,omitempty"`
 	}
 	InputHTTP struct {
 		Method            string                `json:"method"`
+		Hostname          string                `json:"hostname,omitempty"`
+		URL               string                `json:"url"`
 		Path              string                `json:"path"`
-		Headers           map[string][]string   `json:"headers"`
+		Headers           interface{}           `json:"headers"`
 		ClientCertificate ClientCertificateInfo `json:"client_certificate"`
+		Geo               *InputGeo             `json:"geo,omitempty"`
+	}
+	InputGeo struct {
+		Country string `json:"country,omitempty"`
+		ASN     int    `json:"asn,omitempty"`
 	}
 	InputSession struct {
-		ID string `json:"id"`
+		ID             string `json:"id"`
+		FailedAttempts *int   `json:"failed_attempts,omitempty"`
+		IDPID          string `json:"idp_id,omitempty"`
+		Rate           *int   `json:"rate,omitempty"`
 	}
 	ClientCertificateInfo struct {
-		Presented bool   `json:"presented"`
-		Leaf      string `json:"leaf"`
+		Presented     bool   `json:"presented"`
+		Leaf          string `json:"leaf"`
+		Intermediates string `json:"intermediates,omitempty"`
 	}
 )
 
-func generateRegoFromYAML(raw string) (string, error) {
+func generateRegoFromYAML(raw string, extraOptions ...generator.Option) (string, error) {
 	var options []generator.Option
 	for _, newMatcher := range All() {
 		options = append(options, generator.WithCriterion(newMatcher))
 	}
+	options = append(options, extraOptions...)
 
 	g := generator.New(options...)
 	p := parser.New()
@@ -73,6 +93,118 @@ func generateRegoFromYAML(raw string) (string, error) {
 	return string(bs), nil
 }
 
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	stub := func(g *Generator) Criterion {
+		return generator.NewCriterionFunc(generator.CriterionDataTypeUnused, "stub",
+			func(_ string, _ parser.Value) (*ast.Rule, []*ast.Rule, error) {
+				rule := NewCriterionRule(g, "stub", ReasonAccept, ReasonReject,
+					ast.Body{ast.NewExpr(ast.BooleanTerm(true))})
+				return rule, nil, nil
+			})
+	}
+
+	r := NewRegistry(All()...)
+	r.Register(stub)
+	assert.Len(t, r.All(), len(All())+1)
+	for _, ctor := range All() {
+		assert.NotEqual(t, "stub", ctor(generator.New()).Name(),
+			"should not register the stub criterion globally")
+	}
+
+	policy, err := parser.ParseYAML(strings.NewReader(`
+allow:
+  and:
+    - stub: 1
+    - http_method:
+        is: GET
+`))
+	require.NoError(t, err)
+	mod, err := generator.New(r.GeneratorOptions()...).Generate(policy)
+	require.NoError(t, err)
+
+	res, err := rego.New(
+		rego.Module("policy.rego", string(format.MustAst(mod))),
+		rego.Query("result = data.pomerium.policy"),
+		rego.Input(Input{HTTP: InputHTTP{Method: "GET"}}),
+		rego.SetRegoVersion(ast.RegoV1),
+	).Eval(context.Background())
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, A{true, A{ReasonAccept, ReasonHTTPMethodOK}, M{}},
+		res[0].Bindings["result"].(map[string]interface{})["allow"])
+
+	_, err = generator.New(NewRegistry(All()...).GeneratorOptions()...).Generate(policy)
+	assert.EqualError(t, err, "unknown policy criterion: stub")
+}
+
+func TestBuildModule(t *testing.T) {
+	t.Parallel()
+
+	data, err := parser.ParseValue(strings.NewReader(
+		`{"fingerprint": "17859273e8a980631d367b2d5a6a6635412b0f22835f69e47b3f65624546a704"}`))
+	require.NoError(t, err)
+	mod, err := generator.BuildModule(ClientCertificate(generator.New()), data)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		label    string
+		cert     string
+		expected A
+	}{
+		{"match", testCert, A{true, A{ReasonClientCertificateOK}, M{}}},
+		{"mismatch", testCertWithSANs, A{false, A{ReasonClientCertificateUnauthorized}, M{}}},
+	} {
+		res, err := rego.New(
+			rego.ParsedModule(mod),
+			rego.Query("result = data.pomerium.policy"),
+			rego.Input(Input{HTTP: InputHTTP{ClientCertificate: ClientCertificateInfo{
+				Presented: true,
+				Leaf:      tc.cert,
+			}}}),
+			rego.SetRegoVersion(ast.RegoV1),
+		).Eval(context.Background())
+		require.NoError(t, err, tc.label)
+		require.Len(t, res, 1, tc.label)
+		result := res[0].Bindings["result"].(map[string]interface{})
+		assert.Equal(t, tc.expected, result["allow"], tc.label)
+		assert.Equal(t, A{false, A{}}, result["deny"], tc.label)
+	}
+
+	_, err = generator.BuildModule(ClientCertificate(generator.New()), parser.Array{})
+	assert.EqualError(t, err, "error generating criterion rules: certificate matcher array must not be empty")
+}
+
+func TestNewTemplatedReasonExpr(t *testing.T) {
+	t.Parallel()
+
+	expr := NewTemplatedReasonExpr(ast.VarTerm("reason"), ReasonClientCertificateOK,
+		ReasonField{Name: "CN", Value: ast.VarTerm("cn")})
+	assert.Equal(t, `reason := concat("", ["client-certificate-ok", ":CN=", `+
+		`substring(regex.replace(cn, "[^A-Za-z0-9 .:@_-]", "_"), 0, 64)])`,
+		strings.TrimSpace(string(format.MustAst(expr))))
+
+	for _, tc := range []struct {
+		cn, reason string
+	}{
+		{"bob", "client-certificate-ok:CN=bob"},
+		{"bob,evil=1\n{}", "client-certificate-ok:CN=bob_evil_1___"},
+		{strings.Repeat("x", 100), "client-certificate-ok:CN=" + strings.Repeat("x", maxReasonFieldLength)},
+	} {
+		rs, err := rego.New(
+			rego.Input(map[string]any{"cn": tc.cn}),
+			rego.ParsedQuery(ast.Body{
+				ast.Assign.Expr(ast.VarTerm("cn"), ast.MustParseTerm("input.cn")),
+				expr,
+			}),
+		).Eval(context.Background())
+		require.NoError(t, err)
+		require.Len(t, rs, 1)
+		assert.Equal(t, tc.reason, rs[0].Bindings["reason"])
+	}
+}
+
 func makeRecord(object interface {
 	proto.Message
 	GetId() string
@@ -101,8 +233,9 @@ func evaluate(t *testing.T,
 	rawPolicy string,
 	dataBrokerRecords []*databroker.Record,
 	input Input,
+	options ...generator.Option,
 ) (rego.Vars, error) {
-	regoPolicy, err := generateRegoFromYAML(rawPolicy)
+	regoPolicy, err := generateRegoFromYAML(rawPolicy, options...)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing policy: %w", err)
 	}
@@ -141,6 +274,7 @@ func evaluate(t *testing.T,
 
 			return nil, nil
 		}),
+		IDNAToASCIIRegoOption,
 		rego.Input(input),
 		rego.SetRegoVersion(ast.RegoV1),
 	)
@@ -0,0 +1,105 @@
+package criteria
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+	"github.com/pomerium/pomerium/pkg/webauthnutil"
+)
+
+const (
+	deviceCredentialOperatorEnrolled = "enrolled"
+	deviceCredentialOperatorType     = "type"
+)
+
+var deviceCredentialOperatorLookup = map[string]struct{}{
+	deviceCredentialOperatorEnrolled: {},
+	deviceCredentialOperatorType:     {},
+}
+
+// deviceCredentialTypes maps the credential types to the field of the
+// credential's specifier which is set for that type.
+var deviceCredentialTypes = map[string]string{
+	"webauthn": "Webauthn",
+}
+
+type deviceCredentialCriterion struct {
+	g *Generator
+}
+
+func (deviceCredentialCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (deviceCredentialCriterion) Name() string {
+	return "device_credential"
+}
+
+func (c deviceCredentialCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for device_credential criterion, got: %T", data)
+	}
+
+	for k := range obj {
+		_, ok := deviceCredentialOperatorLookup[k]
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected field in device_credential criterion: %s", k)
+		}
+	}
+
+	// a session without a device credential is denied
+	body := ast.Body{
+		ast.MustParseExpr(`device_credential.id != ""`),
+	}
+
+	if v, ok := obj[deviceCredentialOperatorType]; ok {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected string for device_credential criterion type operator, got %T", v)
+		}
+		field, ok := deviceCredentialTypes[string(s)]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported device credential type: %s", s)
+		}
+		body = append(body, ast.NotEqual.Expr(
+			ast.ObjectGet.Call(ast.VarTerm("device_credential"),
+				ast.ArrayTerm(ast.StringTerm("Specifier"), ast.StringTerm(field)), ast.NullTerm()),
+			ast.NullTerm()))
+	}
+
+	if v, ok := obj[deviceCredentialOperatorEnrolled]; ok {
+		enrolled, ok := v.(parser.Boolean)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected boolean for device_credential criterion enrolled operator, got %T", v)
+		}
+		if enrolled {
+			body = append(body, ast.MustParseExpr(`device_enrollment.id != ""`))
+		} else {
+			body = append(body, ast.MustParseExpr(`object.get(device_enrollment, "id", "") == ""`))
+		}
+	}
+
+	rule := NewCriterionDeviceRule(c.g, c.Name(),
+		ReasonDeviceOK, ReasonDeviceUnauthorized,
+		body, webauthnutil.DefaultDeviceType)
+	return rule, []*ast.Rule{
+		rules.GetDeviceCredential(),
+		rules.GetDeviceEnrollment(),
+		rules.GetSession(),
+	}, nil
+}
+
+// DeviceCredential returns a Criterion based on the type and enrollment of
+// the User's device credential.
+func DeviceCredential(generator *Generator) Criterion {
+	return deviceCredentialCriterion{g: generator}
+}
+
+func init() {
+	Register(DeviceCredential)
+}
@@ -0,0 +1,128 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpc/device"
+	"github.com/pomerium/pomerium/pkg/grpc/session"
+)
+
+func TestDeviceCredential(t *testing.T) {
+	deviceSession := &session.Session{
+		Id: "s1",
+		DeviceCredentials: []*session.Session_DeviceCredential{
+			{TypeId: "any", Credential: &session.Session_DeviceCredential_Id{Id: "dc1"}},
+		},
+	}
+	webauthnCredential := &device.Credential{
+		Id:           "dc1",
+		EnrollmentId: "de1",
+		Specifier: &device.Credential_Webauthn{
+			Webauthn: &device.Credential_WebAuthn{Id: []byte("credential-id")},
+		},
+	}
+
+	for _, tc := range []struct {
+		label    string
+		policy   string
+		records  []*databroker.Record
+		expected A
+	}{
+		{
+			"enrolled webauthn",
+			`{type: webauthn, enrolled: true}`,
+			[]*databroker.Record{
+				makeRecord(deviceSession),
+				makeRecord(webauthnCredential),
+				makeRecord(&device.Enrollment{Id: "de1"}),
+			},
+			A{true, A{ReasonDeviceOK}, M{"device_type": "any"}},
+		},
+		{
+			"unenrolled webauthn",
+			`{type: webauthn, enrolled: true}`,
+			[]*databroker.Record{
+				makeRecord(deviceSession),
+				makeRecord(webauthnCredential),
+			},
+			A{false, A{ReasonDeviceUnauthenticated}, M{"device_type": "any"}},
+		},
+		{
+			"unenrolled webauthn allowed",
+			`{type: webauthn, enrolled: false}`,
+			[]*databroker.Record{
+				makeRecord(deviceSession),
+				makeRecord(webauthnCredential),
+			},
+			A{true, A{ReasonDeviceOK}, M{"device_type": "any"}},
+		},
+		{
+			"enrolled webauthn not allowed",
+			`{type: webauthn, enrolled: false}`,
+			[]*databroker.Record{
+				makeRecord(deviceSession),
+				makeRecord(webauthnCredential),
+				makeRecord(&device.Enrollment{Id: "de1"}),
+			},
+			A{false, A{ReasonDeviceUnauthorized}, M{"device_type": "any"}},
+		},
+		{
+			"not webauthn",
+			`{type: webauthn}`,
+			[]*databroker.Record{
+				makeRecord(deviceSession),
+				makeRecord(&device.Credential{Id: "dc1", EnrollmentId: "de1"}),
+				makeRecord(&device.Enrollment{Id: "de1"}),
+			},
+			A{false, A{ReasonDeviceUnauthorized}, M{"device_type": "any"}},
+		},
+		{
+			"no device credential",
+			`{type: webauthn, enrolled: true}`,
+			[]*databroker.Record{
+				makeRecord(deviceSession),
+			},
+			A{false, A{ReasonDeviceUnauthenticated}, M{"device_type": "any"}},
+		},
+		{
+			"no device credentials in session",
+			`{type: webauthn}`,
+			[]*databroker.Record{
+				makeRecord(&session.Session{Id: "s1"}),
+			},
+			A{false, A{ReasonDeviceUnauthenticated}, M{"device_type": "any"}},
+		},
+		{
+			"no session",
+			`{type: webauthn}`,
+			nil,
+			A{false, A{ReasonUserUnauthenticated}, M{"device_type": "any"}},
+		},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - device_credential: `+tc.policy+`
+`, tc.records, Input{Session: InputSession{ID: "s1"}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`device_credential: webauthn`,
+			`device_credential: {type: u2f}`,
+			`device_credential: {type: 1}`,
+			`device_credential: {enrolled: "yes"}`,
+			`device_credential: {approved: true}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
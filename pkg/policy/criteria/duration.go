@@ -0,0 +1,34 @@
+package criteria
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// parseDuration parses the duration value of a condition, like "24h" or
+// "30m". Any unit supported by time.ParseDuration may be used, and the
+// duration must be positive.
+func parseDuration(condition string, data parser.Value) (time.Duration, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return 0, fmt.Errorf("%s expects a duration string (was %v)", condition, data)
+	}
+
+	d, err := time.ParseDuration(string(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s duration (%s): %w", condition, string(s), err)
+	} else if d <= 0 {
+		return 0, fmt.Errorf("%s duration must be positive (was %s)", condition, string(s))
+	}
+	return d, nil
+}
+
+// durationTerm returns a duration as a number of nanoseconds, the unit used by
+// the Rego time functions.
+func durationTerm(d time.Duration) *ast.Term {
+	return ast.IntNumberTerm(int(d.Nanoseconds()))
+}
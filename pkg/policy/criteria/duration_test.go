@@ -0,0 +1,43 @@
+package criteria
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+func TestParseDuration(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		input  parser.Value
+		output time.Duration
+		err    string
+	}{
+		{parser.String("24h"), 24 * time.Hour, ""},
+		{parser.String("30m"), 30 * time.Minute, ""},
+		{parser.String("1h30m"), 90 * time.Minute, ""},
+		{parser.String("500ms"), 500 * time.Millisecond, ""},
+		{parser.String(""), 0, `invalid max_age duration (): time: invalid duration ""`},
+		{parser.String("1d"), 0, `invalid max_age duration (1d): time: unknown unit "d" in duration "1d"`},
+		{parser.String("30"), 0, `invalid max_age duration (30): time: missing unit in duration "30"`},
+		{parser.String("0s"), 0, "max_age duration must be positive (was 0s)"},
+		{parser.String("-1h"), 0, "max_age duration must be positive (was -1h)"},
+		{parser.Number("3600"), 0, "max_age expects a duration string (was 3600)"},
+		{parser.Null{}, 0, "max_age expects a duration string (was null)"},
+	} {
+		d, err := parseDuration("max_age", tc.input)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.input)
+			assert.Equal(t, tc.output, d, tc.input)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.input)
+		}
+	}
+
+	assert.Equal(t, ast.IntNumberTerm(1800000000000), durationTerm(30*time.Minute))
+}
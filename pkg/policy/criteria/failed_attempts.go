@@ -0,0 +1,83 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The number of prior failed authentication attempts is expected in the
+// input as a number:
+//
+//	{"session": {"id": "...", "failed_attempts": 2}}
+//
+// Pomerium doesn't count failed attempts itself, so it's only set by a caller
+// of the authorize evaluator which does, in RequestSession.FailedAttempts. If
+// it's missing the criterion doesn't match.
+var failedAttemptsBody = ast.MustParseBody(`
+	failed_attempts := object.get(object.get(input, "session", {}), "failed_attempts", null)
+	is_number(failed_attempts)
+	failed_attempts <= max_failed_attempts
+`)
+
+type failedAttemptsCriterion struct {
+	g *Generator
+}
+
+func (failedAttemptsCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (failedAttemptsCriterion) Name() string {
+	return "failed_attempts"
+}
+
+func (c failedAttemptsCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for failed attempts criterion, got: %T", data)
+	}
+
+	var body ast.Body
+	for k, v := range obj {
+		switch k {
+		case "max":
+			n, ok := v.(parser.Number)
+			if !ok {
+				return nil, nil, fmt.Errorf("failed attempts max expects an integer (was %v)", v)
+			}
+			i, err := strconv.Atoi(string(n))
+			if err != nil || i < 0 {
+				return nil, nil, fmt.Errorf("failed attempts max expects a non-negative integer (was %s)", string(n))
+			}
+			body = append(body, ast.Assign.Expr(ast.VarTerm("max_failed_attempts"), ast.IntNumberTerm(i)))
+		default:
+			return nil, nil, fmt.Errorf("unsupported failed attempts condition: %s", k)
+		}
+	}
+	if len(body) == 0 {
+		return nil, nil, errors.New("failed attempts criterion requires max")
+	}
+	body = append(body, failedAttemptsBody...)
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonFailedAttemptsOK, ReasonFailedAttemptsUnauthorized,
+		body)
+
+	return rule, nil, nil
+}
+
+// FailedAttempts returns a Criterion which matches if the number of prior
+// failed authentication attempts is at most a maximum.
+func FailedAttempts(generator *Generator) Criterion {
+	return failedAttemptsCriterion{g: generator}
+}
+
+func init() {
+	Register(FailedAttempts)
+}
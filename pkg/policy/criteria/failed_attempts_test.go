@@ -0,0 +1,47 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailedAttempts(t *testing.T) {
+	attempts := func(n int) *int { return &n }
+
+	for _, tc := range []struct {
+		label    string
+		attempts *int
+		expected A
+	}{
+		{"missing", nil, A{false, A{ReasonFailedAttemptsUnauthorized}, M{}}},
+		{"none", attempts(0), A{true, A{ReasonFailedAttemptsOK}, M{}}},
+		{"under", attempts(2), A{true, A{ReasonFailedAttemptsOK}, M{}}},
+		{"at", attempts(3), A{true, A{ReasonFailedAttemptsOK}, M{}}},
+		{"over", attempts(4), A{false, A{ReasonFailedAttemptsUnauthorized}, M{}}},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - failed_attempts:
+        max: 3
+`, nil, Input{Session: InputSession{ID: "SESSION_ID", FailedAttempts: tc.attempts}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`failed_attempts: 3`,
+			`failed_attempts: {max: -1}`,
+			`failed_attempts: {max: "3"}`,
+			`failed_attempts: {min: 3}`,
+			`failed_attempts: {}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The result of a geolocation lookup of the client's IP address is expected
+// in the input. Pomerium doesn't do the lookup itself, so it's only set by a
+// caller of the authorize evaluator which does, in RequestHTTP.Geo:
+//
+//	{"http": {"geo": {"country": "DE", "asn": 64496}}}
+//
+// The country is an ISO 3166-1 alpha-2 code, and the asn is the number of the
+// autonomous system announcing the address. A missing field never matches.
+var (
+	geoCountryBody = ast.MustParseBody(`
+		upper(object.get(object.get(input.http, "geo", {}), "country", "")) == allowed_countries[_]
+	`)
+	geoASNBody = ast.MustParseBody(`
+		object.get(object.get(input.http, "geo", {}), "asn", null) == allowed_asns[_]
+	`)
+)
+
+// An ISO 3166-1 alpha-2 country code.
+var geoCountryRE = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+type geoCriterion struct {
+	g *Generator
+}
+
+func (geoCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (geoCriterion) Name() string {
+	return "geo"
+}
+
+// GenerateRule generates a rule which matches the geolocation of the client,
+// like:
+//
+//	allow:
+//	  and:
+//	    - geo:
+//	        country: [DE, FR]
+//	        asn: 64496
+//
+// If both country and asn are given, both must match.
+func (c geoCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for geo criterion, got: %T", data)
+	}
+	for k := range obj {
+		if k != "country" && k != "asn" {
+			return nil, nil, fmt.Errorf("unsupported geo condition: %s", k)
+		}
+	}
+
+	var body ast.Body
+	if v, ok := obj["country"]; ok {
+		countries, err := parseGeoCountries(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = append(body, ast.Assign.Expr(ast.VarTerm("allowed_countries"), ast.NewTerm(countries)))
+		body = append(body, geoCountryBody...)
+	}
+	if v, ok := obj["asn"]; ok {
+		asns, err := parseGeoASNs(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = append(body, ast.Assign.Expr(ast.VarTerm("allowed_asns"), ast.NewTerm(asns)))
+		body = append(body, geoASNBody...)
+	}
+	if len(body) == 0 {
+		return nil, nil, errors.New("geo criterion requires country or asn")
+	}
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonGeoOK, ReasonGeoUnauthorized,
+		body)
+
+	return rule, nil, nil
+}
+
+// parseGeoCountries returns the uppercase country codes of a geo country
+// condition, which is a country code or an array of them.
+func parseGeoCountries(data parser.Value) (*ast.Array, error) {
+	pa, ok := data.(parser.Array)
+	if !ok {
+		pa = parser.Array{data}
+	}
+
+	ra := ast.NewArray()
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("geo country must be a string (was %v)", v)
+		} else if !geoCountryRE.MatchString(string(s)) {
+			return nil, fmt.Errorf("geo country must be an ISO 3166-1 alpha-2 code (was %s)", string(s))
+		}
+		ra = ra.Append(ast.StringTerm(strings.ToUpper(string(s))))
+	}
+	if ra.Len() == 0 {
+		return nil, errors.New("geo country must not be empty")
+	}
+	return ra, nil
+}
+
+// parseGeoASNs returns the autonomous system numbers of a geo asn condition,
+// which is a number or an array of them.
+func parseGeoASNs(data parser.Value) (*ast.Array, error) {
+	pa, ok := data.(parser.Array)
+	if !ok {
+		pa = parser.Array{data}
+	}
+
+	ra := ast.NewArray()
+	for _, v := range pa {
+		n, ok := v.(parser.Number)
+		if !ok {
+			return nil, fmt.Errorf("geo asn must be a number (was %v)", v)
+		}
+		asn, err := strconv.ParseUint(string(n), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("geo asn must be a 32-bit unsigned integer (was %s)", string(n))
+		}
+		ra = ra.Append(ast.IntNumberTerm(int(asn)))
+	}
+	if ra.Len() == 0 {
+		return nil, errors.New("geo asn must not be empty")
+	}
+	return ra, nil
+}
+
+// Geo returns a Criterion which matches the country or autonomous system of
+// the client, as looked up by the gateway.
+func Geo(generator *Generator) Criterion {
+	return geoCriterion{g: generator}
+}
+
+func init() {
+	Register(Geo)
+}
@@ -0,0 +1,56 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeo(t *testing.T) {
+	ok := A{true, A{ReasonGeoOK}, M{}}
+	unauthorized := A{false, A{ReasonGeoUnauthorized}, M{}}
+	for _, tc := range []struct {
+		label    string
+		policy   string
+		geo      *InputGeo
+		expected A
+	}{
+		{"allowed country", `{country: DE}`, &InputGeo{Country: "DE"}, ok},
+		{"allowed country in list", `{country: [de, fr]}`, &InputGeo{Country: "FR"}, ok},
+		{"allowed country in any case", `{country: DE}`, &InputGeo{Country: "de"}, ok},
+		{"blocked country", `{country: [DE, FR]}`, &InputGeo{Country: "US"}, unauthorized},
+		{"missing country", `{country: DE}`, &InputGeo{ASN: 64496}, unauthorized},
+		{"allowed asn", `{asn: [64496, 64497]}`, &InputGeo{ASN: 64497}, ok},
+		{"blocked asn", `{asn: 64496}`, &InputGeo{ASN: 64511}, unauthorized},
+		{"allowed country and asn", `{country: DE, asn: 64496}`, &InputGeo{Country: "DE", ASN: 64496}, ok},
+		{"allowed country blocked asn", `{country: DE, asn: 64496}`, &InputGeo{Country: "DE", ASN: 64511}, unauthorized},
+		{"no geo", `{country: DE}`, nil, unauthorized},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - geo: `+tc.policy+`
+`, nil, Input{HTTP: InputHTTP{Geo: tc.geo}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`geo: DE`,
+			`geo: {}`,
+			`geo: {country: []}`,
+			`geo: {country: Germany}`,
+			`geo: {country: 49}`,
+			`geo: {asn: AS64496}`,
+			`geo: {asn: -1}`,
+			`geo: {asn: 1.5}`,
+			`geo: {region: BY}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
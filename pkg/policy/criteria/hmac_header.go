@@ -0,0 +1,214 @@
+package criteria
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The HMAC functions of the supported algorithms, by name.
+var hmacHeaderAlgorithms = map[string]*ast.Builtin{
+	"sha256": ast.CryptoHmacSha256,
+	"sha512": ast.CryptoHmacSha512,
+}
+
+const (
+	defaultHMACHeaderTimestampHeader = "X-Signature-Timestamp"
+	defaultHMACHeaderMaxSkew         = 5 * time.Minute
+)
+
+// The signed string is the request method, path and timestamp, separated by
+// newlines, like "GET\n/api/v1/invoices\n1620740580".
+var hmacHeaderSigningString = ast.Concat.Call(ast.StringTerm("\n"), ast.ArrayTerm(
+	ast.MustParseTerm("input.http.method"), ast.MustParseTerm("input.http.path"),
+	ast.VarTerm("hmac_timestamp")))
+
+// A header name is an HTTP token.
+var hmacHeaderNameRE = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+type hmacHeaderCriterion struct {
+	g *Generator
+}
+
+func (hmacHeaderCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (hmacHeaderCriterion) Name() string {
+	return "hmac_header"
+}
+
+// GenerateRule generates a rule which matches requests signed with a shared
+// secret, e.g. by a machine client of a service-to-service route, like:
+//
+//	allow:
+//	  and:
+//	    - hmac_header:
+//	        header: X-Signature
+//	        timestamp_header: X-Signature-Timestamp
+//	        max_skew: 5m
+//	        secret_ref: secrets.billing
+//	        algorithm: sha256
+//
+// The header is the lowercase hex HMAC of the request method, path and
+// timestamp, and the timestamp header is the time the request was signed, in
+// seconds since the epoch. Requests signed more than max_skew from now are
+// rejected, so a captured signature can't be replayed later. secret_ref is the
+// path of the secret in the data document, relative to data, so that the
+// secret itself isn't part of the policy. The timestamp header defaults to
+// X-Signature-Timestamp, max_skew to 5m and the algorithm to sha256.
+// Signatures are compared in constant time.
+func (c hmacHeaderCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	cfg, err := parseHMACHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("hmac_signature"), ast.Lower.Call(
+			ast.CallTerm(ast.RefTerm(ast.VarTerm("get_header")), ast.StringTerm(cfg.header)))),
+		ast.Assign.Expr(ast.VarTerm("hmac_timestamp"),
+			ast.CallTerm(ast.RefTerm(ast.VarTerm("get_header")), ast.StringTerm(cfg.timestampHeader))),
+		ast.RegexMatch.Expr(ast.StringTerm("^[0-9]{1,12}$"), ast.VarTerm("hmac_timestamp")),
+		ast.LessThanEq.Expr(
+			ast.Abs.Call(ast.Minus.Call(ast.NowNanos.Call(), ast.Multiply.Call(
+				ast.ToNumber.Call(ast.VarTerm("hmac_timestamp")), ast.IntNumberTerm(int(time.Second))))),
+			durationTerm(cfg.maxSkew)),
+		ast.Assign.Expr(ast.VarTerm("hmac_secret"), ast.NewTerm(cfg.secret)),
+		ast.IsString.Expr(ast.VarTerm("hmac_secret")),
+		ast.CryptoHmacEqual.Expr(
+			ast.VarTerm("hmac_signature"),
+			cfg.algorithm.Call(hmacHeaderSigningString, ast.VarTerm("hmac_secret"))),
+	}
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonHMACHeaderOK, ReasonHMACHeaderUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetHeader(),
+	}, nil
+}
+
+// hmacHeaderConfig is a parsed hmac_header criterion.
+type hmacHeaderConfig struct {
+	// the canonical names of the signature and timestamp headers
+	header, timestampHeader string
+	secret                  ast.Ref
+	algorithm               *ast.Builtin
+	maxSkew                 time.Duration
+}
+
+// parseHMACHeader parses an hmac_header criterion.
+func parseHMACHeader(data parser.Value) (*hmacHeaderConfig, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, fmt.Errorf("expected object for hmac_header criterion, got: %T", data)
+	}
+	for k := range obj {
+		switch k {
+		case "header", "timestamp_header", "max_skew", "secret_ref", "algorithm":
+		default:
+			return nil, fmt.Errorf("unsupported hmac_header field: %s", k)
+		}
+	}
+
+	cfg := &hmacHeaderConfig{
+		timestampHeader: defaultHMACHeaderTimestampHeader,
+		algorithm:       ast.CryptoHmacSha256,
+		maxSkew:         defaultHMACHeaderMaxSkew,
+	}
+	var err error
+	cfg.header, err = parseHMACHeaderName("header", obj["header"])
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := obj["timestamp_header"]; ok {
+		cfg.timestampHeader, err = parseHMACHeaderName("timestamp_header", v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.timestampHeader == cfg.header {
+		return nil, fmt.Errorf("hmac_header header and timestamp_header must differ (were %s)", cfg.header)
+	}
+	if v, ok := obj["max_skew"]; ok {
+		cfg.maxSkew, err = parseDuration("hmac_header max_skew", v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.secret, err = parseHMACHeaderSecretRef(obj["secret_ref"])
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := obj["algorithm"]; ok {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, fmt.Errorf("hmac_header algorithm expects a string (was %v)", v)
+		}
+		cfg.algorithm, ok = hmacHeaderAlgorithms[strings.ToLower(string(s))]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hmac_header algorithm: %s", string(s))
+		}
+	}
+	return cfg, nil
+}
+
+// parseHMACHeaderName returns the canonical name of a header of an
+// hmac_header criterion.
+func parseHMACHeaderName(field string, v parser.Value) (string, error) {
+	s, ok := v.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("hmac_header %s expects a string (was %v)", field, v)
+	} else if !hmacHeaderNameRE.MatchString(string(s)) {
+		return "", fmt.Errorf("invalid hmac_header %s: %q", field, string(s))
+	}
+	return http.CanonicalHeaderKey(string(s)), nil
+}
+
+// parseHMACHeaderSecretRef returns the reference to the data document of the
+// secret of an hmac_header criterion, like secrets.billing for
+// data.secrets.billing, which is loaded into OPA separately, e.g. from a
+// bundle. The path may not refer to the policy itself.
+func parseHMACHeaderSecretRef(v parser.Value) (ast.Ref, error) {
+	s, ok := v.(parser.String)
+	if !ok {
+		return nil, fmt.Errorf("hmac_header secret_ref expects a string (was %v)", v)
+	}
+
+	segments := strings.Split(string(s), ".")
+	ref := ast.Ref{ast.DefaultRootDocument}
+	for _, segment := range segments {
+		if !certDataPathSegmentRE.MatchString(segment) {
+			return nil, fmt.Errorf("invalid hmac_header secret_ref: %q", string(s))
+		}
+		ref = append(ref, ast.StringTerm(segment))
+	}
+	switch segments[0] {
+	case "data", "pomerium":
+		return nil, fmt.Errorf("hmac_header secret_ref must be relative to data, "+
+			"and not within the policy (was %s)", string(s))
+	}
+	return ref, nil
+}
+
+// HMACHeader returns a Criterion which matches requests signed with a shared
+// secret in a header.
+func HMACHeader(generator *Generator) Criterion {
+	return hmacHeaderCriterion{g: generator}
+}
+
+func init() {
+	Register(HMACHeader)
+}
@@ -0,0 +1,150 @@
+package criteria
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+func TestHMACHeader(t *testing.T) {
+	t.Parallel()
+
+	sign := func(h func() hash.Hash, secret, s string) string {
+		mac := hmac.New(h, []byte(secret))
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+	valid := sign(sha256.New, "s3cr3t", "POST\n/api/v1/invoices\n"+now)
+
+	// the authorize evaluator passes each header as a single string
+	headers := func(kvs ...string) map[string]string {
+		m := make(map[string]string)
+		for i := 0; i < len(kvs); i += 2 {
+			m[kvs[i]] = kvs[i+1]
+		}
+		return m
+	}
+
+	const policy = `{"header": "X-Signature", "secret_ref": "secrets.billing"}`
+	ok := A{true, A{ReasonHMACHeaderOK}, M{}}
+	unauthorized := A{false, A{ReasonHMACHeaderUnauthorized}, M{}}
+	for _, tc := range []struct {
+		label    string
+		policy   string
+		secrets  map[string]interface{}
+		method   string
+		headers  interface{}
+		expected A
+	}{
+		{"valid", policy, M{"billing": "s3cr3t"}, "POST",
+			headers("X-Signature", valid, "X-Signature-Timestamp", now), ok},
+		{"valid header list", policy, M{"billing": "s3cr3t"}, "POST",
+			map[string][]string{"X-Signature": {valid}, "X-Signature-Timestamp": {now}}, ok},
+		{"valid uppercase", `{"header": "x-signature", "secret_ref": "secrets.billing"}`,
+			M{"billing": "s3cr3t"}, "POST",
+			headers("X-Signature", strings.ToUpper(valid), "X-Signature-Timestamp", now), ok},
+		{"valid sha512", `{"header": "X-Signature", "secret_ref": "secrets.billing", "algorithm": "sha512"}`,
+			M{"billing": "s3cr3t"}, "POST", headers(
+				"X-Signature", sign(sha512.New, "s3cr3t", "POST\n/api/v1/invoices\n"+now),
+				"X-Signature-Timestamp", now), ok},
+		{"valid timestamp header", `{"header": "X-Signature", "timestamp_header": "X-Date", "secret_ref": "secrets.billing"}`,
+			M{"billing": "s3cr3t"}, "POST", headers("X-Signature", valid, "X-Date", now), ok},
+		{"valid max skew", `{"header": "X-Signature", "max_skew": "15m", "secret_ref": "secrets.billing"}`,
+			M{"billing": "s3cr3t"}, "POST", headers(
+				"X-Signature", sign(sha256.New, "s3cr3t", "POST\n/api/v1/invoices\n"+stale),
+				"X-Signature-Timestamp", stale), ok},
+		{"wrong algorithm", `{"header": "X-Signature", "secret_ref": "secrets.billing", "algorithm": "sha512"}`,
+			M{"billing": "s3cr3t"}, "POST", headers("X-Signature", valid, "X-Signature-Timestamp", now), unauthorized},
+		{"wrong secret", policy, M{"billing": "other"}, "POST",
+			headers("X-Signature", valid, "X-Signature-Timestamp", now), unauthorized},
+		{"wrong method", policy, M{"billing": "s3cr3t"}, "GET",
+			headers("X-Signature", valid, "X-Signature-Timestamp", now), unauthorized},
+		{"wrong timestamp", policy, M{"billing": "s3cr3t"}, "POST",
+			headers("X-Signature", valid, "X-Signature-Timestamp", strconv.FormatInt(time.Now().Unix()-1, 10)), unauthorized},
+		{"stale timestamp", policy, M{"billing": "s3cr3t"}, "POST", headers(
+			"X-Signature", sign(sha256.New, "s3cr3t", "POST\n/api/v1/invoices\n"+stale),
+			"X-Signature-Timestamp", stale), unauthorized},
+		{"future timestamp", policy, M{"billing": "s3cr3t"}, "POST", headers(
+			"X-Signature", sign(sha256.New, "s3cr3t", "POST\n/api/v1/invoices\n"+future),
+			"X-Signature-Timestamp", future), unauthorized},
+		{"invalid timestamp", policy, M{"billing": "s3cr3t"}, "POST", headers(
+			"X-Signature", sign(sha256.New, "s3cr3t", "POST\n/api/v1/invoices\n1e9"),
+			"X-Signature-Timestamp", "1e9"), unauthorized},
+		{"missing timestamp", policy, M{"billing": "s3cr3t"}, "POST",
+			headers("X-Signature", sign(sha256.New, "s3cr3t", "POST\n/api/v1/invoices\n")), unauthorized},
+		{"invalid signature", policy, M{"billing": "s3cr3t"}, "POST",
+			headers("X-Signature", "deadbeef", "X-Signature-Timestamp", now), unauthorized},
+		{"missing header", policy, M{"billing": "s3cr3t"}, "POST", nil, unauthorized},
+		{"missing secret", policy, M{}, "POST",
+			headers("X-Signature", valid, "X-Signature-Timestamp", now), unauthorized},
+		{"secret not a string", policy, M{"billing": M{"key": "s3cr3t"}}, "POST",
+			headers("X-Signature", valid, "X-Signature-Timestamp", now), unauthorized},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := parser.ParseValue(strings.NewReader(tc.policy))
+			require.NoError(t, err)
+			mod, err := generator.BuildModule(HMACHeader(generator.New()), data)
+			require.NoError(t, err)
+
+			res, err := rego.New(
+				rego.ParsedModule(mod),
+				rego.Store(inmem.NewFromObject(map[string]interface{}{"secrets": tc.secrets})),
+				rego.Query("result = data.pomerium.policy"),
+				rego.Input(Input{HTTP: InputHTTP{
+					Method:  tc.method,
+					Path:    "/api/v1/invoices",
+					Headers: tc.headers,
+				}}),
+				rego.SetRegoVersion(ast.RegoV1),
+			).Eval(context.Background())
+			require.NoError(t, err)
+			require.Len(t, res, 1)
+			result := res[0].Bindings["result"].(map[string]interface{})
+			assert.Equal(t, tc.expected, result["allow"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`hmac_header: X-Signature`,
+			`hmac_header: {secret_ref: secrets.billing}`,
+			`hmac_header: {header: X-Signature}`,
+			`hmac_header: {header: "X Signature", secret_ref: secrets.billing}`,
+			`hmac_header: {header: X-Signature, secret_ref: "secrets..billing"}`,
+			`hmac_header: {header: X-Signature, secret_ref: pomerium.secret}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, algorithm: sha3}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, algorithm: md5}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, algorithm: sha1}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, timestamp_header: x-signature}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, timestamp_header: "X Date"}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, max_skew: 300}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, max_skew: "-5m"}`,
+			`hmac_header: {header: X-Signature, secret_ref: secrets.billing, algorithm: 256}`,
+			`hmac_header: {header: X-Signature, secret: s3cr3t}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
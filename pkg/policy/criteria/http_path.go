@@ -1,11 +1,23 @@
 package criteria
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	"github.com/open-policy-agent/opa/ast"
 
 	"github.com/pomerium/pomerium/pkg/policy/parser"
 )
 
+// duplicate slashes are collapsed before the path is matched against a
+// template, but not by the other operators, which match the path as is
+var httpPathTemplateBody = ast.Body{
+	ast.MustParseExpr(`template_path := regex.replace(input.http.path, "/+", "/")`),
+}
+
+const httpPathOperatorTemplate = "template"
+
 type httpPathCriterion struct {
 	g *Generator
 }
@@ -20,6 +32,23 @@ func (httpPathCriterion) Name() string {
 
 func (c httpPathCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
 	var body ast.Body
+
+	// the template operator is only supported for paths, so it's handled here
+	// rather than by the generic string matcher
+	if obj, ok := data.(parser.Object); ok {
+		if v, ok := obj[httpPathOperatorTemplate]; ok {
+			body = append(body, httpPathTemplateBody...)
+			err := matchHTTPPathTemplate(&body, ast.VarTerm("template_path"), v)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			obj = obj.Clone().(parser.Object)
+			delete(obj, httpPathOperatorTemplate)
+			data = obj
+		}
+	}
+
 	ref := ast.RefTerm(ast.VarTerm("input"), ast.VarTerm("http"), ast.VarTerm("path"))
 	err := matchString(&body, ref, data)
 	if err != nil {
@@ -33,6 +62,78 @@ func (c httpPathCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule,
 	return rule, nil, nil
 }
 
+func matchHTTPPathTemplate(dst *ast.Body, left *ast.Term, right parser.Value) error {
+	s, ok := right.(parser.String)
+	if !ok {
+		return fmt.Errorf("expected string for http path template, got: %T", right)
+	}
+
+	pattern, err := compileHTTPPathTemplate(string(s))
+	if err != nil {
+		return err
+	}
+
+	*dst = append(*dst, ast.RegexMatch.Expr(ast.StringTerm(pattern), left))
+	return nil
+}
+
+// A path template segment is either a literal or a placeholder of the form
+// {name} (any non-empty segment) or {name:type}.
+var httpPathTemplatePlaceholderRE = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)(?::([a-z]+))?\}$`)
+
+var httpPathTemplateTypes = map[string]string{
+	"":       `[^/]+`,
+	"string": `[^/]+`,
+	"int":    `[0-9]+`,
+}
+
+// compileHTTPPathTemplate converts a path template, like /users/{id:int}/admin,
+// into an anchored regular expression.
+func compileHTTPPathTemplate(template string) (string, error) {
+	if !strings.HasPrefix(template, "/") {
+		return "", fmt.Errorf("http path template must start with a slash (was %s)", template)
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('^')
+	names := map[string]struct{}{}
+	for _, segment := range strings.Split(template, "/")[1:] {
+		// collapse duplicate slashes
+		if segment == "" {
+			continue
+		}
+
+		sb.WriteByte('/')
+		if !strings.ContainsAny(segment, "{}") {
+			sb.WriteString(regexp.QuoteMeta(segment))
+			continue
+		}
+
+		m := httpPathTemplatePlaceholderRE.FindStringSubmatch(segment)
+		if m == nil {
+			return "", fmt.Errorf("invalid http path template segment: %s", segment)
+		}
+		if _, ok := names[m[1]]; ok {
+			return "", fmt.Errorf("duplicate http path template placeholder: %s", m[1])
+		}
+		names[m[1]] = struct{}{}
+
+		expr, ok := httpPathTemplateTypes[m[2]]
+		if !ok {
+			return "", fmt.Errorf("unknown http path template placeholder type: %s", m[2])
+		}
+		sb.WriteString(expr)
+	}
+	if strings.HasSuffix(template, "/") && template != "/" {
+		sb.WriteByte('/')
+	}
+	if sb.Len() == 1 {
+		sb.WriteByte('/')
+	}
+	sb.WriteByte('$')
+	return sb.String(), nil
+}
+
 // HTTPPath returns a Criterion which matches an HTTP path.
 func HTTPPath(generator *Generator) Criterion {
 	return httpPathCriterion{g: generator}
@@ -3,6 +3,7 @@ package criteria
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/pomerium/pomerium/pkg/grpc/databroker"
@@ -32,3 +33,105 @@ allow:
 		require.Equal(t, A{false, A{}}, res["deny"])
 	})
 }
+
+func TestHTTPPathTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		path     string
+		expected A
+	}{
+		{"placeholder", "/users/{id}/admin", "/users/123/admin", A{true, A{ReasonHTTPPathOK}, M{}}},
+		{"placeholder mismatch", "/users/{id}/admin", "/users/123/other", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+		{"placeholder empty segment", "/users/{id}/admin", "/users/admin", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+		{"placeholder extra segment", "/users/{id}/admin", "/users/1/2/admin", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+		{"int placeholder", "/users/{id:int}", "/users/123", A{true, A{ReasonHTTPPathOK}, M{}}},
+		{"int placeholder mismatch", "/users/{id:int}", "/users/bob", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+		{"literal", "/users/me", "/users/me", A{true, A{ReasonHTTPPathOK}, M{}}},
+		{"literal special characters", "/users/a.b", "/users/axb", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+		{"duplicate slashes in path", "/users/{id}/admin", "//users//123///admin", A{true, A{ReasonHTTPPathOK}, M{}}},
+		{"duplicate slashes in template", "//users//{id}", "/users/123", A{true, A{ReasonHTTPPathOK}, M{}}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := evaluate(t, `
+allow:
+  and:
+    - http_path:
+        template: `+tc.template+`
+`, []*databroker.Record{}, Input{HTTP: InputHTTP{Path: tc.path}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+		})
+	}
+
+	t.Run("starts_with and ends_with", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := evaluate(t, `
+allow:
+  and:
+    - http_path:
+        starts_with: /api/
+        ends_with: /admin
+`, []*databroker.Record{}, Input{HTTP: InputHTTP{Path: "/api//v1/admin"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonHTTPPathOK}, M{}}, res["allow"])
+	})
+
+	t.Run("duplicate slashes without a template", func(t *testing.T) {
+		t.Parallel()
+
+		// only templates collapse duplicate slashes
+		for _, tc := range []struct {
+			matcher, path string
+			expected      A
+		}{
+			{"is: /a//b", "/a//b", A{true, A{ReasonHTTPPathOK}, M{}}},
+			{"is: /a//b", "/a/b", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+			{"is: /a/b", "/a//b", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+			{"starts_with: /admin/", "//admin/users", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+			{"ends_with: /admin", "/users//admin", A{true, A{ReasonHTTPPathOK}, M{}}},
+			{"ends_with: /users/admin", "/users//admin", A{false, A{ReasonHTTPPathUnauthorized}, M{}}},
+		} {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - http_path:
+        `+tc.matcher+`
+`, []*databroker.Record{}, Input{HTTP: InputHTTP{Path: tc.path}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"], tc.matcher+" "+tc.path)
+		}
+	})
+}
+
+func TestCompileHTTPPathTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		template, pattern, err string
+	}{
+		{"/", "^/$", ""},
+		{"/users/{id}/admin", "^/users/[^/]+/admin$", ""},
+		{"/users/{id:int}/", "^/users/[0-9]+/$", ""},
+		{"/a.b/{x:string}", `^/a\.b/[^/]+$`, ""},
+		{"users", "", "http path template must start with a slash (was users)"},
+		{"/users/{id", "", "invalid http path template segment: {id"},
+		{"/users/x{id}", "", "invalid http path template segment: x{id}"},
+		{"/users/{id:uuid}", "", "unknown http path template placeholder type: uuid"},
+		{"/{id}/{id}", "", "duplicate http path template placeholder: id"},
+	} {
+		pattern, err := compileHTTPPathTemplate(tc.template)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.template)
+			assert.Equal(t, tc.pattern, pattern, tc.template)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.template)
+		}
+	}
+}
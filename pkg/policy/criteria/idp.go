@@ -0,0 +1,84 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The identity provider which authenticated the session is in the input by
+// id, as the authorize evaluator sets it from the session state:
+//
+//	{"session": {"id": "...", "idp_id": "..."}}
+var idpBody = ast.MustParseBody(`
+	session := get_session(input.session.id)
+	session.id != ""
+	idp_id := object.get(input.session, "idp_id", "")
+	idp_id == allowed_idp_ids[_]
+`)
+
+type idpCriterion struct {
+	g *Generator
+}
+
+func (idpCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (idpCriterion) Name() string {
+	return "idp"
+}
+
+func (c idpCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, nil, errors.New("idp criterion expects a string or array of strings")
+	}
+
+	ra := ast.NewArray()
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, nil, fmt.Errorf("idp must be a string (was %v)", v)
+		} else if s == "" {
+			return nil, nil, errors.New("idp must not be empty")
+		}
+		ra = ra.Append(ast.StringTerm(string(s)))
+	}
+	if ra.Len() == 0 {
+		return nil, nil, errors.New("idp criterion requires at least one idp")
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("allowed_idp_ids"), ast.NewTerm(ra)),
+	}
+	body = append(body, idpBody...)
+
+	rule := NewCriterionSessionRule(c.g, c.Name(),
+		ReasonIDPOK, ReasonIDPUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetSession(),
+	}, nil
+}
+
+// IDP returns a Criterion which matches the identity provider which
+// authenticated the session.
+func IDP(generator *Generator) Criterion {
+	return idpCriterion{g: generator}
+}
+
+func init() {
+	Register(IDP)
+}
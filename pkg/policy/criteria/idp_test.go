@@ -0,0 +1,64 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpc/session"
+)
+
+func TestIDP(t *testing.T) {
+	records := []*databroker.Record{
+		makeRecord(&session.Session{
+			Id:     "SESSION_ID",
+			UserId: "USER_ID",
+		}),
+	}
+
+	for _, tc := range []struct {
+		label    string
+		records  []*databroker.Record
+		idpID    string
+		expected A
+	}{
+		{"match", records, "corp", A{true, A{ReasonIDPOK}, M{}}},
+		{"match any", records, "partner", A{true, A{ReasonIDPOK}, M{}}},
+		{"no match", records, "other", A{false, A{ReasonIDPUnauthorized}, M{}}},
+		{"case sensitive", records, "CORP", A{false, A{ReasonIDPUnauthorized}, M{}}},
+		{"missing idp", records, "", A{false, A{ReasonIDPUnauthorized}, M{}}},
+		{"no session", nil, "corp", A{false, A{ReasonUserUnauthenticated}, M{}}},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - idp: [corp, partner]
+`, tc.records, Input{Session: InputSession{ID: "SESSION_ID", IDPID: tc.idpID}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("single", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - idp: corp
+`, records, Input{Session: InputSession{ID: "SESSION_ID", IDPID: "corp"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonIDPOK}, M{}}, res["allow"])
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`idp: ""`,
+			`idp: []`,
+			`idp: [1]`,
+			`idp: {is: corp}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
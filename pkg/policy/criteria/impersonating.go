@@ -0,0 +1,90 @@
+package criteria
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The act claim (RFC 8693) identifies the party acting on behalf of the
+// session's subject. It may be stored either as an object or as a list
+// containing that object. Nested act claims identify prior actors in a
+// delegation chain and, as the RFC requires, are ignored: only the current
+// actor is considered.
+var impersonatingBody = ast.MustParseBody(`
+	session := get_session(input.session.id)
+	act := object.get(object.get(session, "claims", {}), "act", null)
+	actors := array.concat([x | is_array(act); x := act[_]; is_object(x)], [act | is_object(act)])
+`)
+
+type impersonatingCriterion struct {
+	g *Generator
+}
+
+func (impersonatingCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (impersonatingCriterion) Name() string {
+	return "impersonating"
+}
+
+func (c impersonatingCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for impersonating criterion, got: %T", data)
+	}
+
+	for k := range obj {
+		if k != "is" && k != "actor_email" {
+			return nil, nil, fmt.Errorf("unsupported impersonating condition: %s", k)
+		}
+	}
+
+	var body ast.Body
+	body = append(body, impersonatingBody...)
+	if v, ok := obj["is"]; ok {
+		b, ok := v.(parser.Boolean)
+		if !ok {
+			return nil, nil, fmt.Errorf("impersonating condition \"is\" expects a boolean (was %v)", v)
+		}
+		if b {
+			body = append(body, ast.MustParseExpr(`count(actors) > 0`))
+		} else {
+			body = append(body, ast.MustParseExpr(`count(actors) == 0`))
+		}
+	}
+	if v, ok := obj["actor_email"]; ok {
+		// a plain string is shorthand for the is operator
+		if s, ok := v.(parser.String); ok {
+			v = parser.Object{"is": s}
+		}
+		body = append(body, ast.MustParseExpr(`actor_email := object.get(actors[0], "email", "")`))
+		err := matchString(&body, ast.VarTerm("actor_email"), v)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rule := NewCriterionSessionRule(c.g, c.Name(),
+		ReasonImpersonatingOK, ReasonImpersonatingUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetSession(),
+	}, nil
+}
+
+// Impersonating returns a Criterion which matches on the actor of a delegated
+// (impersonated) session.
+func Impersonating(generator *Generator) Criterion {
+	return impersonatingCriterion{g: generator}
+}
+
+func init() {
+	Register(Impersonating)
+}
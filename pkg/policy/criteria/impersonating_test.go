@@ -0,0 +1,172 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpc/session"
+)
+
+func TestImpersonating(t *testing.T) {
+	impersonated := makeStructRecord("type.googleapis.com/session.Session", "SESSION_ID", map[string]any{
+		"id": "SESSION_ID",
+		"claims": map[string]any{
+			"sub": "user@example.com",
+			"act": map[string]any{
+				"sub":   "admin",
+				"email": "admin@example.com",
+				"act": map[string]any{
+					"sub":   "support",
+					"email": "support@example.com",
+				},
+			},
+		},
+	})
+	direct := makeRecord(&session.Session{
+		Id: "SESSION_ID",
+		Claims: map[string]*structpb.ListValue{
+			"sub": {Values: []*structpb.Value{structpb.NewStringValue("user@example.com")}},
+		},
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        is: true
+`, []*databroker.Record{}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonUserUnauthenticated}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("impersonated", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        is: true
+`, []*databroker.Record{impersonated}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonImpersonatingOK}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("direct", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        is: true
+`, []*databroker.Record{direct}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonImpersonatingUnauthorized}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("not impersonated", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        is: false
+`, []*databroker.Record{direct}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonImpersonatingOK}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("actor email", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        actor_email: admin@example.com
+`, []*databroker.Record{impersonated}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonImpersonatingOK}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("actor email list claim", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        actor_email:
+          ends_with: "@example.com"
+`,
+			[]*databroker.Record{
+				makeRecord(&session.Session{
+					Id: "SESSION_ID",
+					Claims: map[string]*structpb.ListValue{
+						"act": {Values: []*structpb.Value{structpb.NewStructValue(&structpb.Struct{
+							Fields: map[string]*structpb.Value{
+								"email": structpb.NewStringValue("admin@example.com"),
+							},
+						})}},
+					},
+				}),
+			},
+			Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonImpersonatingOK}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("nested actor email", func(t *testing.T) {
+		// only the current actor is considered, not prior actors
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        actor_email: support@example.com
+`, []*databroker.Record{impersonated}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonImpersonatingUnauthorized}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("direct actor email", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        actor_email: user@example.com
+`, []*databroker.Record{direct}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonImpersonatingUnauthorized}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("unsupported condition", func(t *testing.T) {
+		_, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        actor_sub: admin
+`, nil, Input{})
+		require.ErrorContains(t, err, "unsupported impersonating condition: actor_sub")
+	})
+	t.Run("invalid is", func(t *testing.T) {
+		_, err := evaluate(t, `
+allow:
+  and:
+    - impersonating:
+        is: "yes"
+`, nil, Input{})
+		require.ErrorContains(t, err, `impersonating condition "is" expects a boolean (was "yes")`)
+	})
+	t.Run("stable order", func(t *testing.T) {
+		policy := `
+allow:
+  and:
+    - impersonating:
+        is: true
+        actor_email: admin@example.com
+`
+		expected, err := generateRegoFromYAML(policy)
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			actual, err := generateRegoFromYAML(policy)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+		}
+	})
+}
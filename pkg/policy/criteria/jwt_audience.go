@@ -0,0 +1,81 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The aud claim may be either a single string or an array of strings.
+var jwtAudienceBody = ast.MustParseBody(`
+	session := get_session(input.session.id)
+	aud := object.get(object.get(session, "claims", {}), "aud", [])
+	audiences := array.concat([x | x := aud[_]], [aud | is_string(aud)])
+	audiences[_] == allowed_audiences[_]
+`)
+
+type jwtAudienceCriterion struct {
+	g *Generator
+}
+
+func (jwtAudienceCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (jwtAudienceCriterion) Name() string {
+	return "jwt_audience"
+}
+
+func (c jwtAudienceCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, nil, errors.New("jwt audience criterion expects a string or array of strings")
+	}
+
+	ra := ast.NewArray()
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, nil, fmt.Errorf("jwt audience must be a string (was %v)", v)
+		} else if s == "" {
+			return nil, nil, errors.New("jwt audience must not be empty")
+		}
+		ra = ra.Append(ast.StringTerm(string(s)))
+	}
+	if ra.Len() == 0 {
+		return nil, nil, errors.New("jwt audience criterion requires at least one audience")
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("allowed_audiences"), ast.NewTerm(ra)),
+	}
+	body = append(body, jwtAudienceBody...)
+
+	rule := NewCriterionSessionRule(c.g, c.Name(),
+		ReasonJWTAudienceOK, ReasonJWTAudienceUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetSession(),
+	}, nil
+}
+
+// JWTAudience returns a Criterion which matches if the session's JWT audience
+// includes any of the given values.
+func JWTAudience(generator *Generator) Criterion {
+	return jwtAudienceCriterion{g: generator}
+}
+
+func init() {
+	Register(JWTAudience)
+}
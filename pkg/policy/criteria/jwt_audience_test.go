@@ -0,0 +1,90 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpc/session"
+)
+
+func TestJWTAudience(t *testing.T) {
+	t.Run("no session", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - jwt_audience: api.example.com
+`, []*databroker.Record{}, Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonUserUnauthenticated}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("array aud", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - jwt_audience: api.example.com
+`,
+			[]*databroker.Record{
+				makeRecord(&session.Session{
+					Id: "SESSION_ID",
+					Claims: map[string]*structpb.ListValue{
+						"aud": {Values: []*structpb.Value{
+							structpb.NewStringValue("other.example.com"),
+							structpb.NewStringValue("api.example.com"),
+						}},
+					},
+				}),
+			},
+			Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonJWTAudienceOK}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("string aud", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - jwt_audience:
+        - other.example.com
+        - api.example.com
+`,
+			[]*databroker.Record{
+				makeStructRecord("type.googleapis.com/session.Session", "SESSION_ID", map[string]any{
+					"id":     "SESSION_ID",
+					"claims": map[string]any{"aud": "api.example.com"},
+				}),
+			},
+			Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{true, A{ReasonJWTAudienceOK}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("unauthorized", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - jwt_audience: api.example.com
+`,
+			[]*databroker.Record{
+				makeStructRecord("type.googleapis.com/session.Session", "SESSION_ID", map[string]any{
+					"id":     "SESSION_ID",
+					"claims": map[string]any{"aud": "api.example.com.evil"},
+				}),
+			},
+			Input{Session: InputSession{ID: "SESSION_ID"}})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonJWTAudienceUnauthorized}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("empty", func(t *testing.T) {
+		_, err := evaluate(t, `
+allow:
+  and:
+    - jwt_audience: []
+`, nil, Input{})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,108 @@
+package criteria
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+type maintenanceWindowCriterion struct {
+	g *Generator
+}
+
+func (maintenanceWindowCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (maintenanceWindowCriterion) Name() string {
+	return "maintenance_window"
+}
+
+// GenerateRule generates a rule which matches while the current time is within
+// the window, from its start (inclusive) to its end (exclusive). It's meant to
+// be used in a deny rule, like:
+//
+//	deny:
+//	  or:
+//	    - maintenance_window:
+//	        start: "2024-06-01T02:00:00Z"
+//	        end: "2024-06-01T04:00:00Z"
+func (c maintenanceWindowCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	start, end, err := parseMaintenanceWindow(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("now"), ast.NowNanos.Call()),
+		ast.GreaterThanEq.Expr(ast.VarTerm("now"), ast.IntNumberTerm(int(start.UnixNano()))),
+		ast.LessThan.Expr(ast.VarTerm("now"), ast.IntNumberTerm(int(end.UnixNano()))),
+	}
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonMaintenanceWindow, ReasonNonMaintenanceWindow,
+		body)
+
+	return rule, nil, nil
+}
+
+// parseMaintenanceWindow returns the start and end times of a maintenance
+// window, which must be RFC 3339 timestamps with the end after the start.
+func parseMaintenanceWindow(data parser.Value) (start, end time.Time, err error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return start, end, fmt.Errorf("maintenance_window criterion expects an object (was %v)", data)
+	}
+	for k := range obj {
+		if k != "start" && k != "end" {
+			return start, end, fmt.Errorf("unsupported maintenance_window field: %s", k)
+		}
+	}
+
+	start, err = parseMaintenanceWindowTime(obj, "start")
+	if err != nil {
+		return start, end, err
+	}
+	end, err = parseMaintenanceWindowTime(obj, "end")
+	if err != nil {
+		return start, end, err
+	}
+	if !end.After(start) {
+		return start, end, fmt.Errorf("maintenance_window end must be after start (was %s to %s)",
+			obj["start"], obj["end"])
+	}
+	return start, end, nil
+}
+
+func parseMaintenanceWindowTime(obj parser.Object, field string) (time.Time, error) {
+	v, ok := obj[field]
+	if !ok {
+		return time.Time{}, fmt.Errorf("maintenance_window %s is required", field)
+	}
+	s, ok := v.(parser.String)
+	if !ok {
+		return time.Time{}, fmt.Errorf("maintenance_window %s expects an RFC 3339 timestamp (was %v)", field, v)
+	}
+
+	t, err := time.Parse(time.RFC3339, string(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid maintenance_window %s timestamp (%s): %w", field, string(s), err)
+	} else if !time.Unix(0, t.UnixNano()).Equal(t) {
+		return time.Time{}, fmt.Errorf("maintenance_window %s timestamp is out of range (was %s)", field, string(s))
+	}
+	return t, nil
+}
+
+// MaintenanceWindow returns a Criterion which matches while the current time
+// is within a maintenance window.
+func MaintenanceWindow(generator *Generator) Criterion {
+	return maintenanceWindowCriterion{g: generator}
+}
+
+func init() {
+	Register(MaintenanceWindow)
+}
@@ -0,0 +1,76 @@
+package criteria
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	// the evaluation time is testingNow, 2021-05-11T13:43:00 local time
+	start := testingNow.Add(-time.Hour)
+	for _, tc := range []struct {
+		label      string
+		start, end time.Time
+		expected   A
+	}{
+		{"inside", start, start.Add(2 * time.Hour), A{true, A{ReasonMaintenanceWindow}, M{}}},
+		{"at start", testingNow, testingNow.Add(time.Hour), A{true, A{ReasonMaintenanceWindow}, M{}}},
+		{"at end", start, testingNow, A{false, A{ReasonNonMaintenanceWindow}, M{}}},
+		{"before", testingNow.Add(time.Minute), testingNow.Add(time.Hour), A{false, A{ReasonNonMaintenanceWindow}, M{}}},
+		{"after", start, start.Add(time.Minute), A{false, A{ReasonNonMaintenanceWindow}, M{}}},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := evaluate(t, `
+allow:
+  and:
+    - accept: 1
+deny:
+  or:
+    - maintenance_window:
+        start: "`+tc.start.Format(time.RFC3339)+`"
+        end: "`+tc.end.Format(time.RFC3339)+`"
+`, nil, Input{})
+			require.NoError(t, err)
+			assert.Equal(t, A{true, A{ReasonAccept}, M{}}, res["allow"])
+			assert.Equal(t, tc.expected, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			policy string
+			err    string
+		}{
+			{`maintenance_window: "2021-05-11T00:00:00Z"`,
+				"maintenance_window criterion expects an object"},
+			{`maintenance_window: {start: "2021-05-11T00:00:00Z"}`,
+				"maintenance_window end is required"},
+			{`maintenance_window: {end: "2021-05-11T00:00:00Z"}`,
+				"maintenance_window start is required"},
+			{`maintenance_window: {start: "2021-05-11", end: "2021-05-12T00:00:00Z"}`,
+				"invalid maintenance_window start timestamp (2021-05-11)"},
+			{`maintenance_window: {start: "2021-05-11T00:00:00Z", end: 1620777600}`,
+				"maintenance_window end expects an RFC 3339 timestamp"},
+			{`maintenance_window: {start: "0001-01-01T00:00:00Z", end: "2021-05-12T00:00:00Z"}`,
+				"maintenance_window start timestamp is out of range"},
+			{`maintenance_window: {start: "2021-05-12T00:00:00Z", end: "2021-05-11T00:00:00Z"}`,
+				"maintenance_window end must be after start"},
+			{`maintenance_window: {start: "2021-05-11T00:00:00Z", end: "2021-05-11T00:00:00Z"}`,
+				"maintenance_window end must be after start"},
+			{`maintenance_window: {start: "2021-05-11T00:00:00Z", end: "2021-05-12T00:00:00Z", reason: x}`,
+				"unsupported maintenance_window field: reason"},
+		} {
+			_, err := evaluate(t, "deny:\n  or:\n    - "+tc.policy, nil, Input{})
+			assert.ErrorContains(t, err, tc.err, tc.policy)
+		}
+	})
+}
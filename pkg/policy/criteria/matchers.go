@@ -10,20 +10,21 @@ import (
 
 type matcher func(*ast.Body, *ast.Term, parser.Value) error
 
+var stringMatchers = map[string]matcher{
+	"contains":    matchStringContains,
+	"ends_with":   matchStringEndsWith,
+	"is":          matchStringIs,
+	"starts_with": matchStringStartsWith,
+}
+
 func matchString(dst *ast.Body, left *ast.Term, right parser.Value) error {
 	obj, ok := right.(parser.Object)
 	if !ok {
 		return fmt.Errorf("expected object for string matcher, got: %T", right)
 	}
 
-	lookup := map[string]matcher{
-		"contains":    matchStringContains,
-		"ends_with":   matchStringEndsWith,
-		"is":          matchStringIs,
-		"starts_with": matchStringStartsWith,
-	}
 	for k, v := range obj {
-		f, ok := lookup[k]
+		f, ok := stringMatchers[k]
 		if !ok {
 			return fmt.Errorf("unknown string matcher operator: %s", k)
 		}
@@ -35,6 +36,22 @@ func matchString(dst *ast.Body, left *ast.Term, right parser.Value) error {
 	return nil
 }
 
+// validateStringMatcher returns the error matchString would return for the
+// given value, without generating any rego.
+func validateStringMatcher(right parser.Value) error {
+	obj, ok := right.(parser.Object)
+	if !ok {
+		return fmt.Errorf("expected object for string matcher, got: %T", right)
+	}
+
+	for k := range obj {
+		if _, ok := stringMatchers[k]; !ok {
+			return fmt.Errorf("unknown string matcher operator: %s", k)
+		}
+	}
+	return nil
+}
+
 func matchStringContains(dst *ast.Body, left *ast.Term, right parser.Value) error {
 	*dst = append(*dst, ast.Contains.Expr(left, ast.NewTerm(right.RegoValue())))
 	return nil
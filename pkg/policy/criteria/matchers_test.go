@@ -50,6 +50,14 @@ func TestStringMatcher(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, `startswith(example, "test")`, str(body))
 	})
+	t.Run("is_not", func(t *testing.T) {
+		// only certificate SAN matchers support is_not
+		var body ast.Body
+		err := matchString(&body, ast.VarTerm("example"), parser.Object{
+			"is_not": parser.String("test"),
+		})
+		assert.EqualError(t, err, "unknown string matcher operator: is_not")
+	})
 }
 
 func TestStringListMatcher(t *testing.T) {
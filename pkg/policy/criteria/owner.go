@@ -0,0 +1,110 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The owner is the URL-decoded path segment, after duplicate slashes are
+// collapsed like for http_path. A + is a literal + in a path, rather than a
+// space, as in alice+test@example.com. A missing or empty segment, or one
+// which can't be decoded, has no owner and so never matches.
+var ownerBody = ast.MustParseBody(`
+	session := get_session(input.session.id)
+	user := get_user(session)
+	email := get_user_email(session, user)
+	owner_segments := split(trim_prefix(regex.replace(input.http.path, "/+", "/"), "/"), "/")
+	owner := urlquery.decode(replace(owner_segments[owner_segment_index], "+", "%2B"))
+	owner != ""
+	email == owner
+`)
+
+type ownerCriterion struct {
+	g *Generator
+}
+
+func (ownerCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (ownerCriterion) Name() string {
+	return "owner"
+}
+
+// GenerateRule generates a rule which matches if the user's email address is
+// the owner of the resource named by the request path, like:
+//
+//	allow:
+//	  and:
+//	    - owner:
+//	        from_path_segment: 2
+//
+// The segments are numbered from 1, so the owner of /users/alice@example.com/files
+// is the second segment, alice@example.com. Emails are compared exactly, like
+// the email criterion.
+func (c ownerCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	segment, err := parseOwnerPathSegment(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("owner_segment_index"), ast.IntNumberTerm(segment-1)),
+	}
+	body = append(body, ownerBody...)
+
+	rule := NewCriterionSessionRule(c.g, c.Name(),
+		ReasonOwnerOK, ReasonOwnerUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetSession(),
+		rules.GetUser(),
+		rules.GetUserEmail(),
+	}, nil
+}
+
+// parseOwnerPathSegment returns the number of the path segment of an owner
+// criterion, counting from 1.
+func parseOwnerPathSegment(data parser.Value) (int, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return 0, fmt.Errorf("expected object for owner criterion, got: %T", data)
+	}
+	for k := range obj {
+		if k != "from_path_segment" {
+			return 0, fmt.Errorf("unsupported owner condition: %s", k)
+		}
+	}
+
+	v, ok := obj["from_path_segment"]
+	if !ok {
+		return 0, errors.New("owner criterion expects from_path_segment")
+	}
+	n, ok := v.(parser.Number)
+	if !ok {
+		return 0, fmt.Errorf("owner from_path_segment expects an integer (was %v)", v)
+	}
+	segment, err := strconv.Atoi(string(n))
+	if err != nil || segment < 1 {
+		return 0, fmt.Errorf("owner from_path_segment expects a positive integer (was %s)", string(n))
+	}
+	return segment, nil
+}
+
+// Owner returns a Criterion which matches if the user's email address is the
+// owner named by a segment of the request path.
+func Owner(generator *Generator) Criterion {
+	return ownerCriterion{g: generator}
+}
+
+func init() {
+	Register(Owner)
+}
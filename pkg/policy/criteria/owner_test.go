@@ -0,0 +1,90 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/pomerium/pkg/grpc/databroker"
+	"github.com/pomerium/pomerium/pkg/grpc/session"
+	"github.com/pomerium/pomerium/pkg/grpc/user"
+)
+
+func TestOwner(t *testing.T) {
+	records := func(email string) []*databroker.Record {
+		return []*databroker.Record{
+			makeRecord(&session.Session{
+				Id:     "SESSION_ID",
+				UserId: "USER_ID",
+			}),
+			makeRecord(&user.User{
+				Id:    "USER_ID",
+				Email: email,
+			}),
+		}
+	}
+
+	ok := A{true, A{ReasonOwnerOK}, M{}}
+	unauthorized := A{false, A{ReasonOwnerUnauthorized}, M{}}
+	for _, tc := range []struct {
+		label    string
+		email    string
+		path     string
+		expected A
+	}{
+		{"owner", "alice@example.com", "/users/alice@example.com/files", ok},
+		{"non-owner", "bob@example.com", "/users/alice@example.com/files", unauthorized},
+		{"last segment", "alice@example.com", "/users/alice@example.com", ok},
+		{"trailing slash", "alice@example.com", "/users/alice@example.com/", ok},
+		{"encoded", "alice@example.com", "/users/alice%40example.com/files", ok},
+		{"plus", "alice+test@example.com", "/users/alice+test@example.com/files", ok},
+		{"encoded plus", "alice+test@example.com", "/users/alice%2Btest@example.com/files", ok},
+		{"duplicate slashes", "alice@example.com", "//users//alice@example.com/files", ok},
+		{"other segment", "alice@example.com", "/alice@example.com/users/files", unauthorized},
+		{"missing segment", "alice@example.com", "/users", unauthorized},
+		{"empty segment", "alice@example.com", "/users/", unauthorized},
+		{"invalid encoding", "alice@example.com", "/users/alice%zz@example.com/files", unauthorized},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - owner:
+        from_path_segment: 2
+`, records(tc.email), Input{
+				HTTP:    InputHTTP{Path: tc.path},
+				Session: InputSession{ID: "SESSION_ID"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("no session", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - owner:
+        from_path_segment: 2
+`, nil, Input{
+			HTTP:    InputHTTP{Path: "/users/alice@example.com/files"},
+			Session: InputSession{ID: "SESSION_ID"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{ReasonUserUnauthenticated}, M{}}, res["allow"])
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`owner: 2`,
+			`owner: {}`,
+			`owner: {from_path_segment: 0}`,
+			`owner: {from_path_segment: -1}`,
+			`owner: {from_path_segment: 1.5}`,
+			`owner: {from_path_segment: "2"}`,
+			`owner: {from_query: email}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The route is described in the input by its id, and whether it's one of
+// Pomerium's own internal routes, like the routes under /.pomerium/, as set
+// by the authorize evaluator's RequestRoute:
+//
+//	{"route": {"id": "1234", "internal": false}}
+//
+// A route which isn't marked as internal is external.
+var (
+	pomeriumRoutesInternalBody = ast.MustParseBody(`
+		object.get(input, ["route", "internal"], false) == true
+	`)
+	pomeriumRoutesExternalBody = ast.MustParseBody(`
+		object.get(input, ["route", "internal"], false) == false
+	`)
+	pomeriumRoutesIDBody = ast.MustParseBody(`
+		object.get(input, ["route", "id"], null) == pomerium_routes_id
+	`)
+)
+
+type pomeriumRoutesCriterion struct {
+	g *Generator
+}
+
+func (pomeriumRoutesCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (pomeriumRoutesCriterion) Name() string {
+	return "pomerium_routes"
+}
+
+// GenerateRule generates a rule which matches the route of the request, like:
+//
+//	allow:
+//	  and:
+//	    - pomerium_routes:
+//	        internal: false
+//	        route_id: "1234"
+//
+// internal matches whether the route is one of Pomerium's own routes, and
+// route_id matches the id of the route exactly. All of the conditions must
+// match.
+func (c pomeriumRoutesCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for pomerium_routes criterion, got: %T", data)
+	}
+
+	var internal *bool
+	var routeID string
+	for k, v := range obj {
+		switch k {
+		case "internal":
+			b, ok := v.(parser.Boolean)
+			if !ok {
+				return nil, nil, fmt.Errorf("pomerium_routes internal expects a boolean (was %v)", v)
+			}
+			value := bool(b)
+			internal = &value
+		case "route_id":
+			s, ok := v.(parser.String)
+			if !ok {
+				return nil, nil, fmt.Errorf("pomerium_routes route_id expects a string (was %v)", v)
+			} else if s == "" {
+				return nil, nil, errors.New("pomerium_routes route_id must not be empty")
+			}
+			routeID = string(s)
+		default:
+			return nil, nil, fmt.Errorf("unsupported pomerium_routes condition: %s", k)
+		}
+	}
+	if internal == nil && routeID == "" {
+		return nil, nil, errors.New("pomerium_routes criterion requires internal or route_id")
+	}
+
+	var body ast.Body
+	var passReason, failReason Reason
+	if internal != nil {
+		if *internal {
+			body = append(body, pomeriumRoutesInternalBody...)
+			passReason, failReason = ReasonPomeriumRoute, ReasonNonPomeriumRoute
+		} else {
+			body = append(body, pomeriumRoutesExternalBody...)
+			passReason, failReason = ReasonNonPomeriumRoute, ReasonPomeriumRoute
+		}
+	}
+	if routeID != "" {
+		body = append(body, ast.Assign.Expr(ast.VarTerm("pomerium_routes_id"), ast.StringTerm(routeID)))
+		body = append(body, pomeriumRoutesIDBody...)
+		passReason, failReason = ReasonRouteIDOK, ReasonRouteIDUnauthorized
+	}
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		passReason, failReason,
+		body)
+
+	return rule, nil, nil
+}
+
+// PomeriumRoutes returns a Criterion which matches the route of the request.
+func PomeriumRoutes(generator *Generator) Criterion {
+	return pomeriumRoutesCriterion{g: generator}
+}
+
+func init() {
+	Register(PomeriumRoutes)
+}
@@ -0,0 +1,60 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPomeriumRoutes(t *testing.T) {
+	internal := A{true, A{ReasonPomeriumRoute}, M{}}
+	notInternal := A{false, A{ReasonNonPomeriumRoute}, M{}}
+	external := A{true, A{ReasonNonPomeriumRoute}, M{}}
+	notExternal := A{false, A{ReasonPomeriumRoute}, M{}}
+	routeID := A{true, A{ReasonRouteIDOK}, M{}}
+	notRouteID := A{false, A{ReasonRouteIDUnauthorized}, M{}}
+	for _, tc := range []struct {
+		label    string
+		policy   string
+		route    *InputRoute
+		expected A
+	}{
+		{"internal", `{internal: true}`, &InputRoute{Internal: true}, internal},
+		{"internal external route", `{internal: true}`, &InputRoute{ID: "1234"}, notInternal},
+		{"internal no route", `{internal: true}`, nil, notInternal},
+		{"external", `{internal: false}`, &InputRoute{ID: "1234"}, external},
+		{"external internal route", `{internal: false}`, &InputRoute{Internal: true}, notExternal},
+		{"external no route", `{internal: false}`, nil, external},
+		{"route id", `{route_id: "1234"}`, &InputRoute{ID: "1234"}, routeID},
+		{"other route id", `{route_id: "1234"}`, &InputRoute{ID: "5678"}, notRouteID},
+		{"route id no route", `{route_id: "1234"}`, nil, notRouteID},
+		{"external route id", `{internal: false, route_id: "1234"}`, &InputRoute{ID: "1234"}, routeID},
+		{"external route id internal route", `{internal: false, route_id: "1234"}`, &InputRoute{ID: "1234", Internal: true}, notRouteID},
+		{"internal route id", `{internal: true, route_id: "1234"}`, &InputRoute{ID: "1234", Internal: true}, routeID},
+		{"internal route id external route", `{internal: true, route_id: "1234"}`, &InputRoute{ID: "1234"}, notRouteID},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - pomerium_routes: `+tc.policy+`
+`, nil, Input{HTTP: InputHTTP{Path: "/.pomerium/"}, Route: tc.route})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`pomerium_routes: true`,
+			`pomerium_routes: {}`,
+			`pomerium_routes: {internal: "true"}`,
+			`pomerium_routes: {route_id: ""}`,
+			`pomerium_routes: {route_id: 1}`,
+			`pomerium_routes: {path: /.pomerium/}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
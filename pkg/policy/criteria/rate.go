@@ -0,0 +1,83 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The number of requests made by the user in the last minute is expected in
+// the input as a number:
+//
+//	{"session": {"id": "...", "rate": 42}}
+//
+// Pomerium doesn't count requests itself, so it's only set by a caller of the
+// authorize evaluator which does, in RequestSession.Rate. If it's missing the
+// criterion doesn't match.
+var rateBody = ast.MustParseBody(`
+	rate := object.get(object.get(input, "session", {}), "rate", null)
+	is_number(rate)
+	rate <= max_per_minute
+`)
+
+type rateCriterion struct {
+	g *Generator
+}
+
+func (rateCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (rateCriterion) Name() string {
+	return "rate"
+}
+
+func (c rateCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for rate criterion, got: %T", data)
+	}
+
+	var body ast.Body
+	for k, v := range obj {
+		switch k {
+		case "max_per_minute":
+			n, ok := v.(parser.Number)
+			if !ok {
+				return nil, nil, fmt.Errorf("rate max_per_minute expects an integer (was %v)", v)
+			}
+			i, err := strconv.Atoi(string(n))
+			if err != nil || i < 0 {
+				return nil, nil, fmt.Errorf("rate max_per_minute expects a non-negative integer (was %s)", string(n))
+			}
+			body = append(body, ast.Assign.Expr(ast.VarTerm("max_per_minute"), ast.IntNumberTerm(i)))
+		default:
+			return nil, nil, fmt.Errorf("unsupported rate condition: %s", k)
+		}
+	}
+	if len(body) == 0 {
+		return nil, nil, errors.New("rate criterion requires max_per_minute")
+	}
+	body = append(body, rateBody...)
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonRateOK, ReasonRateUnauthorized,
+		body)
+
+	return rule, nil, nil
+}
+
+// Rate returns a Criterion which matches if the number of requests made by the
+// user in the last minute is at most a maximum.
+func Rate(generator *Generator) Criterion {
+	return rateCriterion{g: generator}
+}
+
+func init() {
+	Register(Rate)
+}
@@ -0,0 +1,47 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRate(t *testing.T) {
+	rate := func(n int) *int { return &n }
+
+	for _, tc := range []struct {
+		label    string
+		rate     *int
+		expected A
+	}{
+		{"missing", nil, A{false, A{ReasonRateUnauthorized}, M{}}},
+		{"none", rate(0), A{true, A{ReasonRateOK}, M{}}},
+		{"below", rate(59), A{true, A{ReasonRateOK}, M{}}},
+		{"at", rate(60), A{true, A{ReasonRateOK}, M{}}},
+		{"above", rate(61), A{false, A{ReasonRateUnauthorized}, M{}}},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			res, err := evaluate(t, `
+allow:
+  and:
+    - rate:
+        max_per_minute: 60
+`, nil, Input{Session: InputSession{ID: "SESSION_ID", Rate: tc.rate}})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`rate: 60`,
+			`rate: {max_per_minute: -1}`,
+			`rate: {max_per_minute: "60"}`,
+			`rate: {max_per_hour: 60}`,
+			`rate: {}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
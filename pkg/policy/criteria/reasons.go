@@ -8,11 +8,15 @@ type Reason string
 // Well-known reasons.
 const (
 	ReasonAccept                        = "accept"
+	ReasonAuthAgeOK                     = "auth-age-ok"
+	ReasonAuthAgeUnauthorized           = "auth-age-unauthorized"
 	ReasonClaimOK                       = "claim-ok"
 	ReasonClaimUnauthorized             = "claim-unauthorized"
 	ReasonClientCertificateOK           = "client-certificate-ok"
 	ReasonClientCertificateUnauthorized = "client-certificate-unauthorized"
 	ReasonClientCertificateRequired     = "client-certificate-required"
+	ReasonClientCertificateUnparseable  = "client-certificate-unparseable"
+	ReasonClientCertificateWarning      = "client-certificate-warning"
 	ReasonCORSRequest                   = "cors-request"
 	ReasonDeviceOK                      = "device-ok"
 	ReasonDeviceUnauthenticated         = "device-unauthenticated"
@@ -21,18 +25,44 @@ const (
 	ReasonDomainUnauthorized            = "domain-unauthorized"
 	ReasonEmailOK                       = "email-ok"
 	ReasonEmailUnauthorized             = "email-unauthorized"
+	ReasonFailedAttemptsOK              = "failed-attempts-ok"
+	ReasonFailedAttemptsUnauthorized    = "failed-attempts-unauthorized"
+	ReasonGeoOK                         = "geo-ok"
+	ReasonGeoUnauthorized               = "geo-unauthorized"
 	ReasonGroupsOK                      = "groups-ok"
 	ReasonGroupsUnauthorized            = "groups-unauthorized"
+	ReasonHMACHeaderOK                  = "hmac-header-ok"
+	ReasonHMACHeaderUnauthorized        = "hmac-header-unauthorized"
 	ReasonHTTPMethodOK                  = "http-method-ok"
 	ReasonHTTPMethodUnauthorized        = "http-method-unauthorized"
 	ReasonHTTPPathOK                    = "http-path-ok"
 	ReasonHTTPPathUnauthorized          = "http-path-unauthorized"
+	ReasonIDPOK                         = "idp-ok"
+	ReasonIDPUnauthorized               = "idp-unauthorized"
+	ReasonImpersonatingOK               = "impersonating-ok"
+	ReasonImpersonatingUnauthorized     = "impersonating-unauthorized"
 	ReasonInvalidClientCertificate      = "invalid-client-certificate"
+	ReasonJWTAudienceOK                 = "jwt-audience-ok"
+	ReasonJWTAudienceUnauthorized       = "jwt-audience-unauthorized"
+	ReasonMaintenanceWindow             = "maintenance-window"
 	ReasonNonCORSRequest                = "non-cors-request"
+	ReasonNonMaintenanceWindow          = "non-maintenance-window"
 	ReasonNonPomeriumRoute              = "non-pomerium-route"
+	ReasonOwnerOK                       = "owner-ok"
+	ReasonOwnerUnauthorized             = "owner-unauthorized"
 	ReasonPomeriumRoute                 = "pomerium-route"
+	ReasonRateOK                        = "rate-ok"
+	ReasonRateUnauthorized              = "rate-unauthorized"
+	ReasonRefererOK                     = "referer-ok"
+	ReasonRefererUnauthorized           = "referer-unauthorized"
 	ReasonReject                        = "reject"
+	ReasonRouteFlagOK                   = "route-flag-ok"
+	ReasonRouteFlagUnauthorized         = "route-flag-unauthorized"
+	ReasonRouteIDOK                     = "route-id-ok"
+	ReasonRouteIDUnauthorized           = "route-id-unauthorized"
 	ReasonRouteNotFound                 = "route-not-found"
+	ReasonSchemeOK                      = "scheme-ok"
+	ReasonSchemeUnauthorized            = "scheme-unauthorized"
 	ReasonUserOK                        = "user-ok"
 	ReasonUserUnauthenticated           = "user-unauthenticated" // user needs to log in
 	ReasonUserUnauthorized              = "user-unauthorized"    // user does not have access
@@ -0,0 +1,104 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+	"github.com/pomerium/pomerium/pkg/policy/rules"
+)
+
+// The origin (scheme and host) is extracted from the Referer header. Missing
+// and relative referers have no origin and so never match.
+var refererBody = ast.MustParseBody(`
+	referer := get_header("Referer")
+	referer_parts := regex.find_all_string_submatch_n("^([A-Za-z][A-Za-z0-9+.-]*)://([^/?#]+)", referer, 1)[0]
+	referer_origin := concat("", [lower(referer_parts[1]), "://", lower(referer_parts[2])])
+	referer_origin == allowed_origins[_]
+`)
+
+type refererCriterion struct {
+	g *Generator
+}
+
+func (refererCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (refererCriterion) Name() string {
+	return "referer"
+}
+
+func (c refererCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	var pa parser.Array
+	switch v := data.(type) {
+	case parser.Array:
+		pa = v
+	case parser.String:
+		pa = parser.Array{data}
+	default:
+		return nil, nil, errors.New("referer criterion expects a string or array of strings")
+	}
+
+	ra := ast.NewArray()
+	for _, v := range pa {
+		s, ok := v.(parser.String)
+		if !ok {
+			return nil, nil, fmt.Errorf("referer origin must be a string (was %v)", v)
+		}
+		origin, err := canonicalRefererOrigin(string(s))
+		if err != nil {
+			return nil, nil, err
+		}
+		ra = ra.Append(ast.StringTerm(origin))
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("allowed_origins"), ast.NewTerm(ra)),
+	}
+	body = append(body, refererBody...)
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonRefererOK, ReasonRefererUnauthorized,
+		body)
+
+	return rule, []*ast.Rule{
+		rules.GetHeader(),
+	}, nil
+}
+
+// canonicalRefererOrigin converts an origin, like https://example.com, into
+// the lowercase form compared against the Referer header. Default ports are
+// removed, since browsers omit them.
+func canonicalRefererOrigin(origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", fmt.Errorf("invalid referer origin (%s): %w", origin, err)
+	}
+	if u.Scheme == "" || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("referer origin must be a scheme and host (was %s)", origin)
+	}
+
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	switch {
+	case scheme == "http" && u.Port() == "80",
+		scheme == "https" && u.Port() == "443":
+		host = strings.ToLower(u.Hostname())
+	}
+	return scheme + "://" + host, nil
+}
+
+// Referer returns a Criterion which matches the origin of the Referer header.
+func Referer(generator *Generator) Criterion {
+	return refererCriterion{g: generator}
+}
+
+func init() {
+	Register(Referer)
+}
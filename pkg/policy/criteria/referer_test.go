@@ -0,0 +1,95 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferer(t *testing.T) {
+	t.Parallel()
+
+	policy := `
+allow:
+  and:
+    - referer:
+        - https://example.com
+        - HTTP://Other.Example.com:80/
+`
+	for _, tc := range []struct {
+		label    string
+		referers []string
+		expected A
+	}{
+		{"match", []string{"https://example.com/some/page?q=1"}, A{true, A{ReasonRefererOK}, M{}}},
+		{"match origin only", []string{"https://example.com"}, A{true, A{ReasonRefererOK}, M{}}},
+		{"match case insensitive", []string{"https://EXAMPLE.com/"}, A{true, A{ReasonRefererOK}, M{}}},
+		{"match default port", []string{"http://other.example.com/"}, A{true, A{ReasonRefererOK}, M{}}},
+		{"mismatched scheme", []string{"http://example.com/"}, A{false, A{ReasonRefererUnauthorized}, M{}}},
+		{"mismatched host", []string{"https://example.com.evil.com/"}, A{false, A{ReasonRefererUnauthorized}, M{}}},
+		{"mismatched port", []string{"https://example.com:8443/"}, A{false, A{ReasonRefererUnauthorized}, M{}}},
+		{"userinfo", []string{"https://example.com@evil.com/"}, A{false, A{ReasonRefererUnauthorized}, M{}}},
+		{"relative", []string{"/some/page"}, A{false, A{ReasonRefererUnauthorized}, M{}}},
+		{"empty", []string{""}, A{false, A{ReasonRefererUnauthorized}, M{}}},
+		{"missing", nil, A{false, A{ReasonRefererUnauthorized}, M{}}},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			headers := map[string][]string{}
+			if tc.referers != nil {
+				headers["Referer"] = tc.referers
+			}
+			res, err := evaluate(t, policy, nil, Input{HTTP: InputHTTP{Headers: headers}})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, res["allow"])
+			assert.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("string headers", func(t *testing.T) {
+		t.Parallel()
+
+		// the authorize evaluator passes each header as a single string
+		for _, tc := range []struct {
+			referer  string
+			expected A
+		}{
+			{"https://example.com/some/page", A{true, A{ReasonRefererOK}, M{}}},
+			{"https://example.com.evil.com/", A{false, A{ReasonRefererUnauthorized}, M{}}},
+		} {
+			res, err := evaluate(t, policy, nil, Input{HTTP: InputHTTP{
+				Headers: map[string]string{"Referer": tc.referer},
+			}})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, res["allow"], tc.referer)
+		}
+	})
+}
+
+func TestCanonicalRefererOrigin(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		input, output, err string
+	}{
+		{"https://example.com", "https://example.com", ""},
+		{"https://Example.COM/", "https://example.com", ""},
+		{"https://example.com:443", "https://example.com", ""},
+		{"http://example.com:80", "http://example.com", ""},
+		{"http://example.com:443", "http://example.com:443", ""},
+		{"example.com", "", "referer origin must be a scheme and host (was example.com)"},
+		{"https://example.com/path", "", "referer origin must be a scheme and host (was https://example.com/path)"},
+		{"https://user@example.com", "", "referer origin must be a scheme and host (was https://user@example.com)"},
+		{"https://example.com?q=1", "", "referer origin must be a scheme and host (was https://example.com?q=1)"},
+	} {
+		origin, err := canonicalRefererOrigin(tc.input)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.input)
+			assert.Equal(t, tc.output, origin, tc.input)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.input)
+		}
+	}
+}
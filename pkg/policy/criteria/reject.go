@@ -1,6 +1,9 @@
 package criteria
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/open-policy-agent/opa/ast"
 
 	"github.com/pomerium/pomerium/pkg/policy/generator"
@@ -19,14 +22,48 @@ func (rejectMatcher) Name() string {
 	return "reject"
 }
 
-func (m rejectMatcher) GenerateRule(_ string, _ parser.Value) (*ast.Rule, []*ast.Rule, error) {
+func (m rejectMatcher) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	reasons := []Reason{ReasonReject}
+	if obj, ok := data.(parser.Object); ok {
+		reason, err := parseRejectReason(obj)
+		if err != nil {
+			return nil, nil, err
+		} else if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
 	rule := m.g.NewRule("reject")
-	rule.Head.Value = NewCriterionTerm(false, ReasonReject)
+	rule.Head.Value = NewCriterionTerm(false, reasons...)
 	rule.Body = ast.Body{ast.NewExpr(ast.BooleanTerm(true))}
 	return rule, nil, nil
 }
 
-// Reject returns a Criterion which always returns false.
+// parseRejectReason returns the custom reason of a reject criterion, like
+// {reason: "maintenance"}, which is returned along with the reject reason.
+func parseRejectReason(obj parser.Object) (Reason, error) {
+	for k := range obj {
+		if k != "reason" {
+			return "", fmt.Errorf("unsupported reject option: %s", k)
+		}
+	}
+
+	v, ok := obj["reason"]
+	if !ok {
+		return "", nil
+	}
+
+	s, ok := v.(parser.String)
+	if !ok {
+		return "", fmt.Errorf("reject reason must be a string (was %v)", v)
+	} else if s == "" {
+		return "", errors.New("reject reason must not be empty")
+	}
+	return Reason(s), nil
+}
+
+// Reject returns a Criterion which always returns false. Other than an
+// optional custom reason, its data is unused.
 func Reject(generator *Generator) Criterion {
 	return rejectMatcher{g: generator}
 }
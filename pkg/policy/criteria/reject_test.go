@@ -17,4 +17,27 @@ allow:
 	require.NoError(t, err)
 	require.Equal(t, A{false, A{ReasonReject}, M{}}, res["allow"])
 	require.Equal(t, A{false, A{}}, res["deny"])
+
+	t.Run("custom reason", func(t *testing.T) {
+		res, err := evaluate(t, `
+allow:
+  and:
+    - reject:
+        reason: maintenance
+`, []*databroker.Record{}, Input{})
+		require.NoError(t, err)
+		require.Equal(t, A{false, A{"maintenance", ReasonReject}, M{}}, res["allow"])
+		require.Equal(t, A{false, A{}}, res["deny"])
+	})
+	t.Run("invalid reason", func(t *testing.T) {
+		for _, policy := range []string{
+			`reject: {reason: ""}`,
+			`reject: {reason: 1}`,
+			`reject: {reason: [maintenance]}`,
+			`reject: {message: maintenance}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
 }
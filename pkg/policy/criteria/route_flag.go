@@ -0,0 +1,98 @@
+package criteria
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The route's flags are expected in the input as a map of booleans, which the
+// authorize evaluator sets from the route's flags option:
+//
+//	{"route": {"flags": {"beta": true}}}
+//
+// A missing flag, or one which isn't a boolean, never matches.
+var routeFlagBody = ast.MustParseBody(`
+	route_flag := object.get(input, ["route", "flags", route_flag_name], null)
+	is_boolean(route_flag)
+	route_flag == route_flag_value
+`)
+
+type routeFlagCriterion struct {
+	g *Generator
+}
+
+func (routeFlagCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (routeFlagCriterion) Name() string {
+	return "route_flag"
+}
+
+// GenerateRule generates a rule which matches if a flag of the route has a
+// value, like:
+//
+//	allow:
+//	  and:
+//	    - route_flag:
+//	        name: beta
+//	        value: true
+//
+// The value defaults to true.
+func (c routeFlagCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	obj, ok := data.(parser.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected object for route flag criterion, got: %T", data)
+	}
+
+	name, value := "", true
+	for k, v := range obj {
+		switch k {
+		case "name":
+			s, ok := v.(parser.String)
+			if !ok {
+				return nil, nil, fmt.Errorf("route flag name expects a string (was %v)", v)
+			} else if s == "" {
+				return nil, nil, errors.New("route flag name must not be empty")
+			}
+			name = string(s)
+		case "value":
+			b, ok := v.(parser.Boolean)
+			if !ok {
+				return nil, nil, fmt.Errorf("route flag value expects a boolean (was %v)", v)
+			}
+			value = bool(b)
+		default:
+			return nil, nil, fmt.Errorf("unsupported route flag condition: %s", k)
+		}
+	}
+	if name == "" {
+		return nil, nil, errors.New("route flag criterion requires name")
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("route_flag_name"), ast.StringTerm(name)),
+		ast.Assign.Expr(ast.VarTerm("route_flag_value"), ast.BooleanTerm(value)),
+	}
+	body = append(body, routeFlagBody...)
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonRouteFlagOK, ReasonRouteFlagUnauthorized,
+		body)
+
+	return rule, nil, nil
+}
+
+// RouteFlag returns a Criterion which matches a boolean flag of the route.
+func RouteFlag(generator *Generator) Criterion {
+	return routeFlagCriterion{g: generator}
+}
+
+func init() {
+	Register(RouteFlag)
+}
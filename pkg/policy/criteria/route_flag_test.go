@@ -0,0 +1,56 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteFlag(t *testing.T) {
+	ok := A{true, A{ReasonRouteFlagOK}, M{}}
+	unauthorized := A{false, A{ReasonRouteFlagUnauthorized}, M{}}
+	for _, tc := range []struct {
+		label    string
+		policy   string
+		flags    map[string]interface{}
+		expected A
+	}{
+		{"set", `{name: beta}`, map[string]interface{}{"beta": true}, ok},
+		{"set to true", `{name: beta, value: true}`, map[string]interface{}{"beta": true}, ok},
+		{"set to false", `{name: beta, value: false}`, map[string]interface{}{"beta": false}, ok},
+		{"set to other value", `{name: beta}`, map[string]interface{}{"beta": false}, unauthorized},
+		{"unset", `{name: beta}`, map[string]interface{}{"other": true}, unauthorized},
+		{"unset false", `{name: beta, value: false}`, map[string]interface{}{"other": true}, unauthorized},
+		{"not a boolean", `{name: beta}`, map[string]interface{}{"beta": "true"}, unauthorized},
+		{"no flags", `{name: beta}`, nil, unauthorized},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			input := Input{}
+			if tc.flags != nil {
+				input.Route = &InputRoute{Flags: tc.flags}
+			}
+			res, err := evaluate(t, `
+allow:
+  and:
+    - route_flag: `+tc.policy+`
+`, nil, input)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res["allow"])
+			require.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		for _, policy := range []string{
+			`route_flag: beta`,
+			`route_flag: {}`,
+			`route_flag: {value: true}`,
+			`route_flag: {name: ""}`,
+			`route_flag: {name: 1}`,
+			`route_flag: {name: beta, value: "true"}`,
+			`route_flag: {name: beta, default: true}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			require.Error(t, err, policy)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package criteria
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+
+	"github.com/pomerium/pomerium/pkg/policy/generator"
+	"github.com/pomerium/pomerium/pkg/policy/parser"
+)
+
+// The scheme is extracted from the request URL. URL schemes are case
+// insensitive, so it is lowercased before comparison.
+var schemeBody = ast.MustParseBody(`
+	url_parts := regex.find_all_string_submatch_n("^([A-Za-z][A-Za-z0-9+.-]*):", object.get(input.http, "url", ""), 1)[0]
+	lower(url_parts[1]) == allowed_scheme
+`)
+
+// schemes are the request schemes a policy may require.
+var schemes = map[string]struct{}{
+	"http":  {},
+	"https": {},
+}
+
+type schemeCriterion struct {
+	g *Generator
+}
+
+func (schemeCriterion) DataType() CriterionDataType {
+	return generator.CriterionDataTypeUnknown
+}
+
+func (schemeCriterion) Name() string {
+	return "scheme"
+}
+
+func (c schemeCriterion) GenerateRule(_ string, data parser.Value) (*ast.Rule, []*ast.Rule, error) {
+	s, ok := data.(parser.String)
+	if !ok {
+		return nil, nil, fmt.Errorf("scheme criterion expects a string (was %v)", data)
+	}
+	// like the request's, the configured scheme is case insensitive
+	scheme := strings.ToLower(string(s))
+	if _, ok := schemes[scheme]; !ok {
+		return nil, nil, fmt.Errorf("unsupported scheme: %s", s)
+	}
+
+	body := ast.Body{
+		ast.Assign.Expr(ast.VarTerm("allowed_scheme"), ast.StringTerm(scheme)),
+	}
+	body = append(body, schemeBody...)
+
+	rule := NewCriterionRule(c.g, c.Name(),
+		ReasonSchemeOK, ReasonSchemeUnauthorized,
+		body)
+
+	return rule, nil, nil
+}
+
+// Scheme returns a Criterion which matches the scheme (http or https) of the
+// request URL.
+func Scheme(generator *Generator) Criterion {
+	return schemeCriterion{g: generator}
+}
+
+func init() {
+	Register(Scheme)
+}
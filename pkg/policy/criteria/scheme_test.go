@@ -0,0 +1,58 @@
+package criteria
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheme(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		label    string
+		scheme   string
+		url      string
+		expected A
+	}{
+		{"https match", "https", "https://example.com/some/path", A{true, A{ReasonSchemeOK}, M{}}},
+		{"https mismatch", "https", "http://example.com/some/path", A{false, A{ReasonSchemeUnauthorized}, M{}}},
+		{"https uppercase", "https", "HTTPS://example.com/", A{true, A{ReasonSchemeOK}, M{}}},
+		{"http match", "http", "http://example.com/", A{true, A{ReasonSchemeOK}, M{}}},
+		{"http mismatch", "http", "https://example.com/", A{false, A{ReasonSchemeUnauthorized}, M{}}},
+		{"uppercase scheme", "HTTPS", "https://example.com/", A{true, A{ReasonSchemeOK}, M{}}},
+		{"mixed case scheme", "Http", "HTTP://example.com/", A{true, A{ReasonSchemeOK}, M{}}},
+		{"uppercase scheme mismatch", "HTTPS", "http://example.com/", A{false, A{ReasonSchemeUnauthorized}, M{}}},
+		{"relative", "https", "/some/path", A{false, A{ReasonSchemeUnauthorized}, M{}}},
+		{"missing", "https", "", A{false, A{ReasonSchemeUnauthorized}, M{}}},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := evaluate(t, `
+allow:
+  and:
+    - scheme: `+tc.scheme+`
+`, nil, Input{HTTP: InputHTTP{URL: tc.url}})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, res["allow"])
+			assert.Equal(t, A{false, A{}}, res["deny"])
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, policy := range []string{
+			`scheme: ""`,
+			`scheme: ftp`,
+			`scheme: FTP`,
+			`scheme: [https]`,
+			`scheme: {is: https}`,
+		} {
+			_, err := evaluate(t, "allow:\n  and:\n    - "+policy, nil, Input{})
+			assert.Error(t, err, policy)
+		}
+	})
+}
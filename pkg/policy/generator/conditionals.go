@@ -29,6 +29,51 @@ var (
 	}
 )
 
+// groupBodies are the bodies of the rules combining the results of a group
+// of rules, by operator.
+var groupBodies = map[string]ast.Body{
+	"and": andBody,
+	"or":  orBody,
+	"not": notBody,
+	"nor": norBody,
+}
+
+// Group generates a rule which combines the results of the given rules, like
+// the main rules of several criteria, with one of the operators of a policy
+// rule: "and", "or", "not" (none of the rules match), or "nor" (not all of
+// the rules match). As group rules are themselves rules, groups can be nested
+// to combine different criteria, like (A AND B) OR C:
+//
+//	ab, err := g.Group("and", a, b)
+//	...
+//	rule, err := g.Group("or", ab, c)
+//
+// The reasons and additional data of the result are merged from the rules
+// which determine it, as for a policy rule. Only the group rule itself is
+// generated, so the grouped rules, and their additional rules, must still be
+// added to the module, e.g. as the additional rules of a criterion.
+func (g *Generator) Group(op string, rules ...*ast.Rule) (*ast.Rule, error) {
+	body, ok := groupBodies[op]
+	if !ok {
+		return nil, fmt.Errorf("unsupported group operator: %s", op)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s group expects at least one rule", op)
+	}
+
+	terms := make([]*ast.Term, 0, len(rules))
+	for _, r := range rules {
+		terms = append(terms, ast.VarTerm(string(r.Head.Name)))
+	}
+
+	rule := g.NewRule(op)
+	rule.Head.Value = ast.VarTerm("v")
+	rule.Body = append(ast.Body{
+		ast.Assign.Expr(ast.VarTerm("results"), ast.ArrayTerm(terms...)),
+	}, body...)
+	return rule, nil
+}
+
 func (g *Generator) generateAndRule(dst *ast.RuleSet, policyCriteria []parser.Criterion) (*ast.Rule, error) {
 	rule := g.NewRule("and")
 
@@ -130,7 +175,7 @@ func (g *Generator) generateCriterionRules(dst *ast.RuleSet, policyCriteria []pa
 		}
 		mainRule, additionalRules, err := criterion.GenerateRule(policyCriterion.SubPath, policyCriterion.Data)
 		if err != nil {
-			return nil, fmt.Errorf("error generating criterion rules: %w", err)
+			return nil, fmt.Errorf("error generating criterion rules: %w", policyCriterion.LocateError(err))
 		}
 		*dst = dst.Merge(additionalRules)
 		dst.Add(mainRule)
@@ -2,19 +2,36 @@
 package generator
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"sort"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
 
 	"github.com/pomerium/pomerium/pkg/policy/parser"
 	"github.com/pomerium/pomerium/pkg/policy/rules"
 )
 
+// DefaultMaxConditionValues is the default limit on the number of values of
+// a criterion's condition, like a list of fingerprints.
+const DefaultMaxConditionValues = 10000
+
 // A Generator generates a rego script from a policy.
 type Generator struct {
-	ids      map[string]int
-	criteria map[string]Criterion
+	ids       map[string]int
+	criteria  map[string]Criterion
+	pins      map[string]string
+	denylist  map[string][]string
+	zones     map[string][]string
+	bundle    []byte
+	strict    bool
+	maxValues int
 }
 
 // An Option configures the Generator.
@@ -28,11 +45,64 @@ func WithCriterion(criterionConstructor CriterionConstructor) Option {
 	}
 }
 
+// WithPins sets the named pins which criteria may reference in place of a
+// literal value, e.g. certificate fingerprints templated from the environment.
+func WithPins(pins map[string]string) Option {
+	return func(g *Generator) {
+		g.pins = pins
+	}
+}
+
+// WithSANDenylists sets the named lists of certificate SANs, e.g. from an
+// external revocation list, which criteria may reference to deny access.
+func WithSANDenylists(denylists map[string][]string) Option {
+	return func(g *Generator) {
+		g.denylist = denylists
+	}
+}
+
+// WithNetworkZones sets the named sets of CIDRs, e.g. the networks of a site,
+// which criteria may reference to match IP addresses.
+func WithNetworkZones(zones map[string][]string) Option {
+	return func(g *Generator) {
+		g.zones = zones
+	}
+}
+
+// WithTrustBundle sets the PEM-encoded bundle of trusted CA certificates, e.g.
+// the deployed trust store, whose fingerprints criteria may reference in place
+// of literal fingerprints.
+func WithTrustBundle(bundle []byte) Option {
+	return func(g *Generator) {
+		g.bundle = bundle
+	}
+}
+
+// WithStrictValues disables the tolerant parsing of criterion values, such as
+// stripping trailing comments from certificate fingerprints, or whitespace
+// from certificate SAN values.
+func WithStrictValues() Option {
+	return func(g *Generator) {
+		g.strict = true
+	}
+}
+
+// WithMaxConditionValues sets the limit on the number of values of a
+// criterion's condition, like a list of fingerprints, so that a misconfigured
+// policy can't generate a huge rule. A limit of 0 or less disables it. The
+// default is DefaultMaxConditionValues.
+func WithMaxConditionValues(n int) Option {
+	return func(g *Generator) {
+		g.maxValues = n
+	}
+}
+
 // New creates a new Generator.
 func New(options ...Option) *Generator {
 	g := &Generator{
-		ids:      make(map[string]int),
-		criteria: make(map[string]Criterion),
+		ids:       make(map[string]int),
+		criteria:  make(map[string]Criterion),
+		maxValues: DefaultMaxConditionValues,
 	}
 	for _, o := range options {
 		o(g)
@@ -46,6 +116,81 @@ func (g *Generator) GetCriterion(name string) (Criterion, bool) {
 	return c, ok
 }
 
+// LookupPin returns the value of the named pin.
+func (g *Generator) LookupPin(name string) (string, bool) {
+	v, ok := g.pins[name]
+	return v, ok
+}
+
+// LookupSANDenylist returns the named list of denied certificate SANs.
+func (g *Generator) LookupSANDenylist(name string) ([]string, bool) {
+	v, ok := g.denylist[name]
+	return v, ok
+}
+
+// LookupNetworkZone returns the CIDRs of the named network zone.
+func (g *Generator) LookupNetworkZone(name string) ([]string, bool) {
+	v, ok := g.zones[name]
+	return v, ok
+}
+
+// TrustBundleFingerprints returns the SHA-256 fingerprints of the
+// certificates in the trust bundle, as lowercase hex. It's an error if there's
+// no trust bundle, or if it isn't a list of PEM-encoded certificates.
+func (g *Generator) TrustBundleFingerprints() ([]string, error) {
+	if len(g.bundle) == 0 {
+		return nil, errors.New("trust bundle is not set")
+	}
+
+	var fingerprints []string
+	rest := g.bundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		} else if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid trust bundle: unexpected PEM block: %s", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid trust bundle: %w", err)
+		}
+		sum := sha256.Sum256(block.Bytes)
+		fingerprints = append(fingerprints, hex.EncodeToString(sum[:]))
+	}
+	if len(fingerprints) == 0 {
+		return nil, errors.New("invalid trust bundle: no certificates")
+	}
+	return fingerprints, nil
+}
+
+// StrictValues returns true if criterion values should be parsed strictly.
+func (g *Generator) StrictValues() bool {
+	return g.strict
+}
+
+// MaxConditionValues returns the limit on the number of values of a
+// criterion's condition, or 0 or less if there's no limit.
+func (g *Generator) MaxConditionValues() int {
+	return g.maxValues
+}
+
+// SettingsHash returns a hash of the settings which affect how criteria
+// generate rules, i.e. everything but the criteria themselves. Generators with
+// the same settings generate the same rules for the same policy.
+func (g *Generator) SettingsHash() [sha256.Size]byte {
+	// maps are marshaled with sorted keys, so this is stable
+	bs, _ := json.Marshal(struct {
+		Pins      map[string]string
+		Denylist  map[string][]string
+		Zones     map[string][]string
+		Bundle    []byte
+		Strict    bool
+		MaxValues int
+	}{g.pins, g.denylist, g.zones, g.bundle, g.strict, g.maxValues})
+	return sha256.Sum256(bs)
+}
+
 // Generate generates the rego module from a policy.
 func (g *Generator) Generate(policy *parser.Policy) (*ast.Module, error) {
 	rs := ast.NewRuleSet()
@@ -133,6 +278,33 @@ func (g *Generator) Generate(policy *parser.Policy) (*ast.Module, error) {
 	return mod, nil
 }
 
+// BuildModule builds a complete rego module, with the default allow and deny
+// decisions, from a single criterion and its data. The criterion is the only
+// criterion of an allow rule, so the module's allow decision is its result.
+// This is useful to test a criterion in isolation.
+//
+// Unlike a module from Generate, the module is parsed from its source, so it
+// may be evaluated directly with rego.ParsedModule.
+func BuildModule(c Criterion, data parser.Value) (*ast.Module, error) {
+	g := New(WithCriterion(func(_ *Generator) Criterion {
+		return c
+	}))
+	mod, err := g.Generate(&parser.Policy{
+		Rules: []parser.Rule{{
+			Action: parser.ActionAllow,
+			And:    []parser.Criterion{{Name: c.Name(), Data: data}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	bs, err := format.Ast(mod)
+	if err != nil {
+		return nil, err
+	}
+	return ast.ParseModuleWithOpts("policy.rego", string(bs), ast.ParserOptions{RegoVersion: ast.RegoV1})
+}
+
 // NewRuleFromTemplate creates a new rule from a template rule.
 func (g *Generator) NewRuleFromTemplate(name string, template *ast.Rule) *ast.Rule {
 	id := g.ids[name]
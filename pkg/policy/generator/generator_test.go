@@ -1,10 +1,12 @@
 package generator
 
 import (
+	"context"
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/rego"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -151,6 +153,11 @@ deny := v if {
 }
 
 invert_criterion_result(v) := out if {
+	v[2].fail_closed == true
+	out = v
+}
+
+else := out if {
 	v[0]
 	out = array.concat([false], array.slice(v, 1, count(v)))
 }
@@ -210,3 +217,140 @@ else := [false, reasons, additional_data] if {
 }
 `, string(format.MustAst(mod)))
 }
+
+func TestGroup(t *testing.T) {
+	t.Parallel()
+
+	// each input rule's result is the input value of the same name, with the
+	// name as its reason
+	input := func(g *Generator, name string) *ast.Rule {
+		rule := g.NewRule(name)
+		rule.Head.Value = ast.ArrayTerm(
+			ast.RefTerm(ast.VarTerm("input"), ast.StringTerm(name)),
+			ast.SetTerm(ast.StringTerm(name)),
+			ast.ObjectTerm())
+		rule.Body = ast.NewBody(ast.NewExpr(ast.BooleanTerm(true)))
+		return rule
+	}
+	// (a AND b) OR (NOT c)
+	group := func(g *Generator) (*ast.Rule, []*ast.Rule, error) {
+		a, b, c := input(g, "a"), input(g, "b"), input(g, "c")
+		ab, err := g.Group("and", a, b)
+		if err != nil {
+			return nil, nil, err
+		}
+		notC, err := g.Group("not", c)
+		if err != nil {
+			return nil, nil, err
+		}
+		rule, err := g.Group("or", ab, notC)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rule, []*ast.Rule{a, b, c, ab, notC}, nil
+	}
+
+	t.Run("rules", func(t *testing.T) {
+		t.Parallel()
+
+		g := New()
+		rule, additionalRules, err := group(g)
+		require.NoError(t, err)
+
+		var rs ast.RuleSet
+		rs = rs.Merge(additionalRules)
+		rs.Add(rule)
+		mod := &ast.Module{
+			Package: &ast.Package{Path: ast.Ref{
+				ast.StringTerm("policy.rego"), ast.StringTerm("pomerium"), ast.StringTerm("policy"),
+			}},
+			Imports: []*ast.Import{{Path: ast.MustParseTerm("rego.v1")}},
+			Rules:   rs,
+		}
+		assert.Equal(t, `package pomerium.policy
+
+import rego.v1
+
+a_0 := [input.a, {"a"}, {}]
+
+b_0 := [input.b, {"b"}, {}]
+
+c_0 := [input.c, {"c"}, {}]
+
+and_0 := v if {
+	results := [a_0, b_0]
+	normalized := [normalize_criterion_result(x) | x := results[i]]
+	v := merge_with_and(normalized)
+}
+
+not_0 := v if {
+	results := [c_0]
+	normalized := [normalize_criterion_result(x) | x := results[i]]
+	inverted := [invert_criterion_result(x) | x := results[i]]
+	v := merge_with_and(inverted)
+}
+
+or_0 := v if {
+	results := [and_0, not_0]
+	normalized := [normalize_criterion_result(x) | x := results[i]]
+	v := merge_with_or(normalized)
+}
+`, string(format.MustAst(mod)))
+	})
+
+	// the group's rules are named by the same generator as the policy's rules
+	g := New(WithCriterion(func(g *Generator) Criterion {
+		return NewCriterionFunc(CriterionDataTypeUnused, "group",
+			func(_ string, _ parser.Value) (*ast.Rule, []*ast.Rule, error) {
+				return group(g)
+			})
+	}))
+	generated, err := g.Generate(&parser.Policy{
+		Rules: []parser.Rule{{
+			Action: parser.ActionAllow,
+			And:    []parser.Criterion{{Name: "group"}},
+		}},
+	})
+	require.NoError(t, err)
+	mod, err := ast.ParseModuleWithOpts("policy.rego", string(format.MustAst(generated)),
+		ast.ParserOptions{RegoVersion: ast.RegoV1})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		label    string
+		a, b, c  bool
+		expected []interface{}
+	}{
+		{"a and b", true, true, true, []interface{}{true, []interface{}{"a", "b"}, map[string]interface{}{}}},
+		{"not c", false, false, false, []interface{}{true, []interface{}{"c"}, map[string]interface{}{}}},
+		{"both", true, true, false, []interface{}{true, []interface{}{"a", "b", "c"}, map[string]interface{}{}}},
+		{"only a", true, false, true, []interface{}{false, []interface{}{"b", "c"}, map[string]interface{}{}}},
+		{"neither", false, false, true, []interface{}{false, []interface{}{"a", "b", "c"}, map[string]interface{}{}}},
+	} {
+		tc := tc
+		t.Run(tc.label, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := rego.New(
+				rego.ParsedModule(mod),
+				rego.Query("result = data.pomerium.policy.allow"),
+				rego.Input(map[string]interface{}{"a": tc.a, "b": tc.b, "c": tc.c}),
+				rego.SetRegoVersion(ast.RegoV1),
+			).Eval(context.Background())
+			require.NoError(t, err)
+			require.Len(t, res, 1)
+			assert.Equal(t, tc.expected, res[0].Bindings["result"])
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		g := New()
+		a := input(g, "a")
+		_, err := g.Group("xor", a)
+		assert.Error(t, err)
+		_, err = g.Group("and")
+		assert.Error(t, err)
+	})
+}
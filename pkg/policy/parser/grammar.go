@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -36,7 +37,13 @@ func (p *Policy) ToJSON() Value {
 
 // PolicyFromValue converts a value into a Policy.
 func PolicyFromValue(v Value) (*Policy, error) {
-	rules, err := RulesFromValue(v)
+	return policyFromValue(v, nil)
+}
+
+// policyFromValue converts a value into a Policy, setting the positions of
+// each criterion's data from the positions of the values in v.
+func policyFromValue(v Value, ps Positions) (*Policy, error) {
+	rules, err := rulesFromValue(v, ps, "")
 	if err != nil {
 		return nil, fmt.Errorf("invalid rules in policy: %w", err)
 	}
@@ -58,11 +65,15 @@ type Rule struct {
 // RulesFromValue converts a Value into a slice of Rules. Only Arrays or Objects
 // are supported.
 func RulesFromValue(v Value) ([]Rule, error) {
+	return rulesFromValue(v, nil, "")
+}
+
+func rulesFromValue(v Value, ps Positions, pointer string) ([]Rule, error) {
 	switch t := v.(type) {
 	case Array:
-		return RulesFromArray(t)
+		return rulesFromArray(t, ps, pointer)
 	case Object:
-		return RulesFromObject(t)
+		return rulesFromObject(t, ps, pointer)
 	default:
 		return nil, fmt.Errorf("unsupported type for rule: %T", v)
 	}
@@ -71,11 +82,15 @@ func RulesFromValue(v Value) ([]Rule, error) {
 // RulesFromArray converts an Array into a slice of Rules. Each element of the Array is
 // converted using RulesFromObject and merged together.
 func RulesFromArray(a Array) ([]Rule, error) {
+	return rulesFromArray(a, nil, "")
+}
+
+func rulesFromArray(a Array, ps Positions, pointer string) ([]Rule, error) {
 	var rules []Rule
-	for _, v := range a {
+	for i, v := range a {
 		switch t := v.(type) {
 		case Object:
-			inner, err := RulesFromObject(t)
+			inner, err := rulesFromObject(t, ps, appendPointer(pointer, strconv.Itoa(i)))
 			if err != nil {
 				return nil, err
 			}
@@ -94,6 +109,10 @@ func RulesFromArray(a Array) ([]Rule, error) {
 //  1. An object where the keys are the actions and the values are an object with "and", "or", or "not" fields:
 //     `{ "allow": { "and": [ {"groups": "group1"} ] } }`
 func RulesFromObject(o Object) ([]Rule, error) {
+	return rulesFromObject(o, nil, "")
+}
+
+func rulesFromObject(o Object, ps Positions, pointer string) ([]Rule, error) {
 	var rules []Rule
 	for k, v := range o {
 		action, err := ActionFromValue(String(k))
@@ -109,7 +128,7 @@ func RulesFromObject(o Object) ([]Rule, error) {
 		rule := Rule{
 			Action: action,
 		}
-		err = rule.fillConditionalsFromObject(oo)
+		err = rule.fillConditionalsFromObject(oo, ps, appendPointer(pointer, k))
 		if err != nil {
 			return nil, err
 		}
@@ -164,7 +183,7 @@ func (r *Rule) ToJSON() Value {
 	}
 }
 
-func (r *Rule) fillConditionalsFromObject(o Object) error {
+func (r *Rule) fillConditionalsFromObject(o Object, ps Positions, pointer string) error {
 	conditionals := []struct {
 		Name     string
 		Criteria *[]Criterion
@@ -176,7 +195,7 @@ func (r *Rule) fillConditionalsFromObject(o Object) error {
 	}
 	for _, cond := range conditionals {
 		if rawCriteria, ok := o[cond.Name]; ok {
-			criteria, err := CriteriaFromValue(rawCriteria)
+			criteria, err := criteriaFromValue(rawCriteria, ps, appendPointer(pointer, cond.Name))
 			if err != nil {
 				return fmt.Errorf("invalid criteria in \"%s\"): %w", cond.Name, err)
 			}
@@ -197,17 +216,25 @@ func (r *Rule) fillConditionalsFromObject(o Object) error {
 //
 // Criteria RegoRulesGenerators are registered based on the specified name.
 // Data is arbitrary JSON data sent to the generator.
+//
+// Positions are the positions in the source of Data and the values within it,
+// relative to Data. They're only set by a Parser created WithPositions.
 type Criterion struct {
-	Name    string
-	SubPath string
-	Data    Value
+	Name      string
+	SubPath   string
+	Data      Value
+	Positions Positions
 }
 
 // CriteriaFromValue converts a Value into Criteria. Only Arrays are supported.
 func CriteriaFromValue(v Value) ([]Criterion, error) {
+	return criteriaFromValue(v, nil, "")
+}
+
+func criteriaFromValue(v Value, ps Positions, pointer string) ([]Criterion, error) {
 	switch t := v.(type) {
 	case Array:
-		return CriteriaFromArray(t)
+		return criteriaFromArray(t, ps, pointer)
 	default:
 		return nil, fmt.Errorf("unsupported type for criteria: %T", v)
 	}
@@ -216,11 +243,15 @@ func CriteriaFromValue(v Value) ([]Criterion, error) {
 // CriteriaFromArray converts an Array into Criteria. Each element of the Array is
 // converted using CriterionFromObject.
 func CriteriaFromArray(a Array) ([]Criterion, error) {
+	return criteriaFromArray(a, nil, "")
+}
+
+func criteriaFromArray(a Array, ps Positions, pointer string) ([]Criterion, error) {
 	var criteria []Criterion
-	for _, v := range a {
+	for i, v := range a {
 		switch t := v.(type) {
 		case Object:
-			inner, err := CriterionFromObject(t)
+			inner, err := criterionFromObject(t, ps, appendPointer(pointer, strconv.Itoa(i)))
 			if err != nil {
 				return nil, err
 			}
@@ -239,6 +270,10 @@ func CriteriaFromArray(a Array) ([]Criterion, error) {
 //  1. An object where the keys are the names with a sub path and the values are the corresponding
 //     data for each Criterion: `{ "groups": "group1" }`
 func CriterionFromObject(o Object) (*Criterion, error) {
+	return criterionFromObject(o, nil, "")
+}
+
+func criterionFromObject(o Object, ps Positions, pointer string) (*Criterion, error) {
 	if len(o) != 1 {
 		return nil, fmt.Errorf("each criteria may only contain a single key and value")
 	}
@@ -250,9 +285,10 @@ func CriterionFromObject(o Object) (*Criterion, error) {
 			name, subPath = k[:idx], k[idx+1:]
 		}
 		return &Criterion{
-			Name:    name,
-			SubPath: subPath,
-			Data:    v,
+			Name:      name,
+			SubPath:   subPath,
+			Data:      v,
+			Positions: ps.sub(appendPointer(pointer, k)),
 		}, nil
 	}
 
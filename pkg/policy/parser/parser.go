@@ -82,27 +82,65 @@ import (
 )
 
 // A Parser parses raw policy definitions into a Policy.
-type Parser struct{}
+type Parser struct {
+	positions bool
+}
+
+// An Option configures the Parser.
+type Option func(*Parser)
+
+// WithPositions records the positions in the source of each criterion's data,
+// so that errors generating the criterion's rules can report where in the
+// source they occurred, e.g. for editor diagnostics.
+func WithPositions() Option {
+	return func(p *Parser) {
+		p.positions = true
+	}
+}
 
 // New creates a new Parser.
-func New() *Parser {
+func New(options ...Option) *Parser {
 	p := &Parser{}
+	for _, o := range options {
+		o(p)
+	}
 	return p
 }
 
 // ParseJSON parses a raw JSON document into a policy.
 func (p *Parser) ParseJSON(r io.Reader) (*Policy, error) {
-	doc, err := ParseValue(r)
+	if !p.positions {
+		doc, err := ParseValue(r)
+		if err != nil {
+			return nil, err
+		}
+		return PolicyFromValue(doc)
+	}
+
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := ParseValue(bytes.NewReader(bs))
 	if err != nil {
 		return nil, err
 	}
-	return PolicyFromValue(doc)
+	ps, err := jsonPositions(bs)
+	if err != nil {
+		return nil, err
+	}
+	return policyFromValue(doc, ps)
 }
 
 // ParseYAML parses a raw YAML document into a policy.
 func (p *Parser) ParseYAML(r io.Reader) (*Policy, error) {
+	var node yaml.Node
+	err := yaml.NewDecoder(r).Decode(&node)
+	if err != nil {
+		return nil, err
+	}
 	var obj interface{}
-	err := yaml.NewDecoder(r).Decode(&obj)
+	err = node.Decode(&obj)
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +148,17 @@ func (p *Parser) ParseYAML(r io.Reader) (*Policy, error) {
 	if err != nil {
 		return nil, err
 	}
-	return p.ParseJSON(bytes.NewReader(bs))
+	doc, err := ParseValue(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+
+	var ps Positions
+	if p.positions {
+		ps = make(Positions)
+		yamlPositions(&node, "", ps)
+	}
+	return policyFromValue(doc, ps)
 }
 
 // ParseJSON creates a parser and calls ParseJSON on it.
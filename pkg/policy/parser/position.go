@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Position is a location in the source of a policy. Lines and columns start
+// at 1, and columns are counted in characters.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// String returns the position as "line L, column C".
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Positions maps JSON pointers (RFC 6901) to the positions in the source of
+// the values they point to.
+type Positions map[string]Position
+
+// sub returns the positions of the value at pointer and the values within it,
+// relative to that value.
+func (ps Positions) sub(pointer string) Positions {
+	if ps == nil {
+		return nil
+	}
+
+	sub := make(Positions)
+	for k, p := range ps {
+		if k == pointer {
+			sub[""] = p
+		} else if strings.HasPrefix(k, pointer+"/") {
+			sub[k[len(pointer):]] = p
+		}
+	}
+	return sub
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// appendPointer returns the JSON pointer of the value at key within the value
+// at pointer. Array indices are keys too.
+func appendPointer(pointer string, keys ...string) string {
+	for _, k := range keys {
+		pointer += "/" + pointerEscaper.Replace(k)
+	}
+	return pointer
+}
+
+// A ValueError is an error caused by a value within a criterion's data. Path
+// is the JSON pointer of the value, relative to the data.
+type ValueError struct {
+	Path string
+	Err  error
+}
+
+// ErrorAt returns err as caused by the value at keys within a value. Keys are
+// object keys or array indices, outermost first, and an error which is already
+// a ValueError is nested within them. This allows errors to be annotated as
+// they're returned from the functions handling each level of the data.
+func ErrorAt(err error, keys ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	var ve *ValueError
+	if errors.As(err, &ve) {
+		return &ValueError{Path: appendPointer("", keys...) + ve.Path, Err: ve.Err}
+	}
+	return &ValueError{Path: appendPointer("", keys...), Err: err}
+}
+
+// Error returns the underlying error's message.
+func (e *ValueError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// A PositionError is an error at a position in the source of a policy.
+type PositionError struct {
+	Position Position
+	Err      error
+}
+
+// Error returns the underlying error's message prefixed with its position.
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Position, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// LocateError returns err as a PositionError at the position of the value in
+// the criterion's data which caused it. If that value's position isn't known,
+// for example because the error isn't a ValueError, the position of the
+// closest enclosing value is used. If the criterion has no positions err is
+// returned unchanged.
+func (c *Criterion) LocateError(err error) error {
+	var path string
+	var ve *ValueError
+	if errors.As(err, &ve) {
+		path = ve.Path
+	}
+
+	for {
+		if p, ok := c.Positions[path]; ok {
+			return &PositionError{Position: p, Err: err}
+		}
+		if path == "" {
+			return err
+		}
+		path = path[:strings.LastIndex(path, "/")]
+	}
+}
+
+// jsonPositions returns the positions of the values in a JSON document.
+func jsonPositions(data []byte) (Positions, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	ps := make(Positions)
+
+	var walk func(pointer string) error
+	walk = func(pointer string) error {
+		// the offset is the end of the previous token, so skip any
+		// separators to find the start of the value
+		start := int(dec.InputOffset())
+		for start < len(data) && strings.IndexByte(" \t\r\n,:", data[start]) >= 0 {
+			start++
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		ps[pointer] = offsetPosition(data, start)
+
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				k, _ := tok.(string)
+				err = walk(appendPointer(pointer, k))
+				if err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				err := walk(appendPointer(pointer, strconv.Itoa(i)))
+				if err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+
+	err := walk("")
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// offsetPosition returns the position of a byte offset in data.
+func offsetPosition(data []byte, offset int) Position {
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return Position{Line: line, Column: utf8.RuneCount(before[lineStart:]) + 1}
+}
+
+// yamlPositions adds the positions of the values in a YAML node to ps.
+// Aliases have the position of their anchor, and values merged into a
+// mapping with << have no position of their own.
+func yamlPositions(node *yaml.Node, pointer string, ps Positions) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			yamlPositions(node.Content[0], pointer, ps)
+		}
+		return
+	case yaml.AliasNode:
+		if node.Alias != nil {
+			yamlPositions(node.Alias, pointer, ps)
+		}
+		return
+	}
+
+	ps[pointer] = Position{Line: node.Line, Column: node.Column}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+			if k == "<<" {
+				continue
+			}
+			yamlPositions(node.Content[i+1], appendPointer(pointer, k), ps)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			yamlPositions(n, appendPointer(pointer, strconv.Itoa(i)), ps)
+		}
+	}
+}
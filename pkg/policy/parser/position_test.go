@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("json", func(t *testing.T) {
+		p, err := New(WithPositions()).ParseJSON(strings.NewReader(`{
+  "allow": {
+    "and": [
+      {"http_method": {"is": "GET"}},
+      {"client_certificate": {"fingerprint": ["a", "ü", "b/c"]}}
+    ]
+  }
+}`))
+		require.NoError(t, err)
+		require.Len(t, p.Rules, 1)
+		require.Len(t, p.Rules[0].And, 2)
+		assert.Equal(t, Positions{
+			"":    {Line: 4, Column: 23},
+			"/is": {Line: 4, Column: 30},
+		}, p.Rules[0].And[0].Positions)
+		assert.Equal(t, Positions{
+			"":               {Line: 5, Column: 30},
+			"/fingerprint":   {Line: 5, Column: 46},
+			"/fingerprint/0": {Line: 5, Column: 47},
+			"/fingerprint/1": {Line: 5, Column: 52},
+			"/fingerprint/2": {Line: 5, Column: 57},
+		}, p.Rules[0].And[1].Positions)
+	})
+	t.Run("yaml", func(t *testing.T) {
+		p, err := New(WithPositions()).ParseYAML(strings.NewReader(`
+allow:
+  and:
+    - http_method:
+        is: GET
+    - client_certificate:
+        fingerprint:
+          - a
+          - &b b
+        "a/b~c": *b
+`))
+		require.NoError(t, err)
+		require.Len(t, p.Rules, 1)
+		require.Len(t, p.Rules[0].And, 2)
+		assert.Equal(t, Positions{
+			"":    {Line: 5, Column: 9},
+			"/is": {Line: 5, Column: 13},
+		}, p.Rules[0].And[0].Positions)
+		assert.Equal(t, Positions{
+			"":               {Line: 7, Column: 9},
+			"/fingerprint":   {Line: 8, Column: 11},
+			"/fingerprint/0": {Line: 8, Column: 13},
+			"/fingerprint/1": {Line: 9, Column: 13},
+			"/a~1b~0c":       {Line: 9, Column: 13},
+		}, p.Rules[0].And[1].Positions)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		p, err := New().ParseYAML(strings.NewReader(`
+allow:
+  and:
+    - http_method:
+        is: GET
+`))
+		require.NoError(t, err)
+		require.Len(t, p.Rules, 1)
+		require.Len(t, p.Rules[0].And, 1)
+		assert.Nil(t, p.Rules[0].And[0].Positions)
+	})
+}
+
+func TestLocateError(t *testing.T) {
+	t.Parallel()
+
+	c := Criterion{Positions: Positions{
+		"":         {Line: 1, Column: 5},
+		"/a":       {Line: 2, Column: 7},
+		"/a/0":     {Line: 3, Column: 9},
+		"/b~1c":    {Line: 4, Column: 7},
+		"/b~1c/d":  {Line: 5, Column: 9},
+		"/missing": {Line: 6, Column: 7},
+	}}
+	errBad := errors.New("bad")
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		expect string
+	}{
+		{"data", errBad, "line 1, column 5: bad"},
+		{"key", ErrorAt(errBad, "a"), "line 2, column 7: bad"},
+		{"index", ErrorAt(errBad, "a", "0"), "line 3, column 9: bad"},
+		{"nested", ErrorAt(ErrorAt(errBad, "d"), "b/c"), "line 5, column 9: bad"},
+		{"enclosing", ErrorAt(errBad, "a", "1"), "line 2, column 7: bad"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := c.LocateError(tc.err)
+			assert.EqualError(t, err, tc.expect)
+			assert.ErrorIs(t, err, errBad)
+
+			var pe *PositionError
+			assert.True(t, errors.As(err, &pe))
+		})
+	}
+
+	t.Run("no positions", func(t *testing.T) {
+		err := ErrorAt(errBad, "a")
+		assert.Equal(t, err, (&Criterion{}).LocateError(err))
+		assert.EqualError(t, err, "bad")
+	})
+	t.Run("nil", func(t *testing.T) {
+		assert.NoError(t, ErrorAt(nil, "a"))
+	})
+}
@@ -43,6 +43,21 @@ get_user_email(session, user) := v if {
 `)
 }
 
+// GetHeader gets the value of the named request header, or "" if there isn't
+// one. The authorize evaluator passes each header as a string, but the first
+// of a list of values is also accepted.
+func GetHeader() *ast.Rule {
+	return MustParse(`
+get_header(name) := v if {
+	v = input.http.headers[name]
+	is_string(v)
+} else := v if {
+	v = input.http.headers[name][0]
+	is_string(v)
+} else := ""
+`)
+}
+
 // GetDeviceCredential gets the device credential for the given session.
 func GetDeviceCredential() *ast.Rule {
 	return MustParse(`
@@ -97,10 +112,14 @@ merge_with_or(results) := [true, reasons, additional_data] if {
 }
 
 // InvertCriterionResult changes the criterion result's value from false to
-// true, or vice-versa.
+// true, or vice-versa. A result whose additional data has fail_closed set is
+// never inverted, so that it denies even when negated.
 func InvertCriterionResult() *ast.Rule {
 	return MustParse(`
 invert_criterion_result(v) := out if {
+	v[2].fail_closed == true
+	out = v
+} else := out if {
 	v[0]
 	out = array.concat([false], array.slice(v, 1, count(v)))
 } else := out if {